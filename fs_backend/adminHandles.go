@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fs_backend/apierrors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// adminMiddleware lets requests through only when they carry the admin token
+// as a bearer token
+func (apifn *ApiConfig) adminMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if apifn.adminToken == "" {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+		token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			ErrorResponseWriter(res, apierrors.ResErrTokenNotFound, http.StatusBadRequest)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(apifn.adminToken)) != 1 {
+			ErrorResponseWriter(res, apierrors.ResErrTokenInvalid, http.StatusUnauthorized)
+			return
+		}
+		handler(res, req)
+	}
+}
+
+// HandleGarbageCollection returns the report of the latest garbage collection
+// on GET, and runs one right away on POST. ?dryRun=true only reports.
+func (apifn ApiConfig) HandleGarbageCollection(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		resData := make(map[string]any)
+		resData["report"] = apifn.fileService.LastReapReport()
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+
+	dryRun := req.URL.Query().Get("dryRun") == "true"
+	report, err := apifn.fileService.Reap(dryRun)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	resData := make(map[string]any)
+	resData["report"] = report
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
@@ -13,6 +13,10 @@ import (
 type ApiConfig struct {
 	ServerPort string
 	jwtSecret  string
+	adminToken string
+	corsOrigin string
+
+	trashPurgeStop chan struct{}
 
 	graphService         databaseservice.GraphDatabaseService
 	transferPropsService transferpropertiesservice.TransferPropertiesService
@@ -24,7 +28,9 @@ func (apifn *ApiConfig) initialize() {
 	apifn.readEnv()
 	apifn.graphService.Connect()
 	apifn.transferPropsService.Start()
-	apifn.fileService.Initialize()
+	apifn.fileService.Initialize(apifn.graphService, apifn.graphService)
+	apifn.fileService.StartReaper(&apifn.transferPropsService)
+	apifn.startTrashPurge()
 	apifn.mailservice.Initialize()
 }
 
@@ -33,9 +39,14 @@ func (apifn *ApiConfig) readEnv() {
 	if apifn.jwtSecret == "" {
 		log.Default().Println("Needed a JWT secret")
 	}
+	// Admin endpoints stay disabled without a token
+	apifn.adminToken = os.Getenv("ADMIN_TOKEN")
+	// Browsers of other origins can only use the tus endpoint when it is set
+	apifn.corsOrigin = os.Getenv("CORS_ALLOWED_ORIGIN")
 }
 
 func (apifn ApiConfig) close() {
+	apifn.stopTrashPurge()
 	apifn.transferPropsService.Stop()
 	apifn.graphService.Close()
 	apifn.fileService.Cleanup()
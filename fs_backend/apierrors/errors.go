@@ -46,6 +46,22 @@ func (WorkspaceNotFound) Error() string {
 	return "Workspace not found"
 }
 
+type QuotaExceeded struct {
+	WorkspaceName string
+}
+
+func (err QuotaExceeded) Error() string {
+	return "Quota of workspace " + err.WorkspaceName + " exceeded"
+}
+
+type MimeTypeNotAllowed struct {
+	MimeType string
+}
+
+func (err MimeTypeNotAllowed) Error() string {
+	return "Files of type " + err.MimeType + " are not allowed"
+}
+
 /* ---------------------------- Directory Errors ---------------------------- */
 
 type DirectoryNotFound struct {
@@ -68,6 +84,20 @@ func (err DirectoryWithSameNameAlreadyExists) Error() string {
 	return "Directory with name " + err.DirName + " already exists in " + err.ParentDirName
 }
 
+type InvalidListingCursor struct{}
+
+func (err InvalidListingCursor) Error() string {
+	return "Listing cursor is invalid"
+}
+
+type InvalidDestination struct {
+	Location string
+}
+
+func (err InvalidDestination) Error() string {
+	return "Cannot place an item at " + err.Location
+}
+
 /* ------------------------------- File Errors ------------------------------ */
 
 type FileNotFound struct {
@@ -90,6 +120,24 @@ func (err FileWithSameNameAlreadyExists) Error() string {
 	return "File with name " + err.FileName + " already exists in directory " + err.ParentDirName
 }
 
+type ChecksumMismatch struct {
+	FileName string
+}
+
+func (err ChecksumMismatch) Error() string {
+	return "Checksum of file " + err.FileName + " does not match"
+}
+
+/* ------------------------------ Trash Errors ------------------------------ */
+
+type TrashEntryNotFound struct {
+	EntryId string
+}
+
+func (err TrashEntryNotFound) Error() string {
+	return fmt.Sprintf("Trash entry with id %s not found", err.EntryId)
+}
+
 /* ------------------------ Upload Properties Errors ------------------------ */
 
 type UploadIdNotFound struct {
@@ -109,3 +157,21 @@ type RoleNotFound struct {
 func (err RoleNotFound) Error() string {
 	return fmt.Sprintf("Role with id %s not found", err.RoleId)
 }
+
+/* ------------------------------ Storage Errors ----------------------------- */
+
+type BlobNotFound struct {
+	Key string
+}
+
+func (err BlobNotFound) Error() string {
+	return "Blob " + err.Key + " not found"
+}
+
+type EncryptionKeyUnavailable struct {
+	WorkspaceName string
+}
+
+func (err EncryptionKeyUnavailable) Error() string {
+	return "Encryption key of workspace " + err.WorkspaceName + " is not available"
+}
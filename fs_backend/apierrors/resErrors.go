@@ -14,6 +14,7 @@ const (
 	ResErrPermissionDenied       = "permission-denied"
 	ResErrDirAlreadyExists       = "dir-already-exists"
 	ResErrDirNotEmpty            = "dir-not-empty"
+	ResErrFileAlreadyExists      = "file-already-exists"
 	ResErrInvalidUploadId        = "invalid-upload-id"
 	ResErrInvalidWorkspaceName   = "invalid-workspace"
 	ResErrWorkspaceAlreadyExists = "workspace-exists"
@@ -23,6 +24,13 @@ const (
 	ResErrRoleAlreadyAssigned    = "role-already-assigned"
 	ResErrRoleNotAssigned        = "role-not-assigned"
 	ResErrResourceNotFound       = "resource-not-found"
+	ResErrUnsupportedVersion     = "unsupported-version"
+	ResErrOffsetMismatch         = "offset-mismatch"
+	ResErrChecksumMismatch       = "checksum-mismatch"
+	ResErrUploadLocked           = "upload-locked"
+	ResErrEncryptionUnavailable  = "encryption-unavailable"
+	ResErrQuotaExceeded          = "quota-exceeded"
+	ResErrMimeTypeNotAllowed     = "mime-type-not-allowed"
 )
 
 func GetErrorCodeDescription(errorCode string) string {
@@ -53,6 +61,8 @@ func GetErrorCodeDescription(errorCode string) string {
 		return "The requested directory already exists."
 	case ResErrDirNotEmpty:
 		return "The directory not empty. Delete the containing files."
+	case ResErrFileAlreadyExists:
+		return "A file or directory with the same name already exists."
 	case ResErrInvalidUploadId:
 		return "The provided upload ID is not valid."
 	case ResErrInvalidWorkspaceName:
@@ -69,6 +79,20 @@ func GetErrorCodeDescription(errorCode string) string {
 		return "Role already assigned to the user."
 	case ResErrResourceNotFound:
 		return "The requested resource is not found"
+	case ResErrUnsupportedVersion:
+		return "The requested protocol version is not supported."
+	case ResErrOffsetMismatch:
+		return "The upload offset does not match the received bytes."
+	case ResErrChecksumMismatch:
+		return "The checksum of the received data does not match."
+	case ResErrUploadLocked:
+		return "The upload is being processed by another request."
+	case ResErrEncryptionUnavailable:
+		return "The encryption key of the workspace is not available on the server."
+	case ResErrQuotaExceeded:
+		return "The storage quota of the workspace or account is exceeded."
+	case ResErrMimeTypeNotAllowed:
+		return "Files of this type are not allowed in the workspace."
 	default:
 		return ""
 	}
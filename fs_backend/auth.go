@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -29,6 +30,53 @@ func (apiCfg ApiConfig) generateJwtToken(data models.JWTData) (string, error) {
 	return tokenString, err
 }
 
+// Lifetime of a token in a link to the content of a file
+const contentTokenLifetime = 5 * time.Minute
+
+// generateContentToken signs a token letting whoever holds it read the file at
+// the location as the account until it expires. Browsers can put it in the URL
+// of a link or a media element, where no Authorization header can be set.
+func (apiCfg ApiConfig) generateContentToken(accountId string, location string, expiresOn time.Time) (string, error) {
+	token := jwt.NewWithClaims(
+		jwt.SigningMethodHS256,
+		jwt.MapClaims{
+			"iss":       "fs_backend",
+			"purpose":   "content",
+			"accountId": accountId,
+			"location":  location,
+			"exp":       expiresOn.Unix(),
+		},
+	)
+	return token.SignedString([]byte(apiCfg.jwtSecret))
+}
+
+// parseContentToken returns the account a content token was issued to, as long
+// as it has not expired and was issued for the location
+func (apifn *ApiConfig) parseContentToken(tokenString string, location string) (models.JWTData, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, isHmac := token.Method.(*jwt.SigningMethodHMAC); !isHmac {
+			return nil, apierrors.InvalidToken{}
+		}
+		return []byte(apifn.jwtSecret), nil
+	})
+	if err != nil {
+		return models.JWTData{}, apierrors.InvalidToken{}
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != "content" || claims["location"] != location {
+		return models.JWTData{}, apierrors.InvalidToken{}
+	}
+	// Tokens without an expiry pass the validation, so it is required here
+	if _, hasExpiry := claims["exp"]; !hasExpiry {
+		return models.JWTData{}, apierrors.InvalidToken{}
+	}
+	accountId, ok := claims["accountId"].(string)
+	if !ok {
+		return models.JWTData{}, apierrors.InvalidToken{}
+	}
+	return models.JWTData{AccountId: accountId}, nil
+}
+
 func (apifn *ApiConfig) parseJwtFromHeader(req *http.Request) (models.JWTData, error) {
 	tokenString := req.Header.Get("Authorization")
 	if tokenString == "" {
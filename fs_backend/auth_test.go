@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestParseContentToken(t *testing.T) {
+	apiCfg := ApiConfig{jwtSecret: "secret"}
+	valid, _ := apiCfg.generateContentToken("account", "ws/a.txt", time.Now().Add(time.Minute))
+	expired, _ := apiCfg.generateContentToken("account", "ws/a.txt", time.Now().Add(-time.Minute))
+	otherSecret, _ := ApiConfig{jwtSecret: "other"}.generateContentToken("account", "ws/a.txt", time.Now().Add(time.Minute))
+	session, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"accountId": "account",
+		"location":  "ws/a.txt",
+	}).SignedString([]byte("secret"))
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"purpose":   "content",
+		"accountId": "account",
+		"location":  "ws/a.txt",
+	}).SignedString([]byte("secret"))
+
+	tests := []struct {
+		name     string
+		token    string
+		location string
+		ok       bool
+	}{
+		{name: "valid", token: valid, location: "ws/a.txt", ok: true},
+		{name: "other location", token: valid, location: "ws/b.txt", ok: false},
+		{name: "expired", token: expired, location: "ws/a.txt", ok: false},
+		{name: "signed with another secret", token: otherSecret, location: "ws/a.txt", ok: false},
+		{name: "not a content token", token: session, location: "ws/a.txt", ok: false},
+		{name: "no expiry", token: noExpiry, location: "ws/a.txt", ok: false},
+		{name: "garbage", token: "not.a.token", location: "ws/a.txt", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := apiCfg.parseContentToken(test.token, test.location)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if test.ok && claims.AccountId != "account" {
+				t.Errorf("got account %q, want %q", claims.AccountId, "account")
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package databaseservice
 
 import (
+	"errors"
 	"log"
+	"strconv"
 	"strings"
 
 	"fs_backend/apierrors"
@@ -37,7 +39,7 @@ func (gds *GraphDatabaseService) checkDirExistence(location string) (bool, error
 func (gds *GraphDatabaseService) checkForSameFileName(parentLocation string, fileName string) (bool, error) {
 	checkFileCypher := `
 		MATCH (d:Directory)-[:CONTAINS]->(c)
-		WHERE d.location = $parentLocation AND c.Name = $fileName
+		WHERE d.location = $parentLocation AND c.name = $fileName
 		RETURN count(c) as count
 	`
 	checkFileParams := map[string]any{
@@ -54,7 +56,7 @@ func (gds *GraphDatabaseService) checkForSameFileName(parentLocation string, fil
 		return false, err
 	}
 	contentsCount, found := checkFileRes.Records[0].Get("count")
-	if !found || contentsCount.(int64) != 1 {
+	if !found || contentsCount.(int64) == 0 {
 		return false, nil
 	}
 	return true, nil
@@ -106,37 +108,60 @@ func (gds *GraphDatabaseService) CreateDirectory(directory models.Directory) err
 	return nil
 }
 
-func (gds GraphDatabaseService) CreateFile(file models.File) error {
+// CreateFile creates the file, or makes it the new version of the file with
+// the same name. Besides the stored file, it returns the files whose content
+// is not referenced any more after dropping versions beyond the retention.
+func (gds GraphDatabaseService) CreateFile(file models.File, versionRetention int64) (models.File, []models.File, error) {
 	locationSplit := strings.Split(file.Location, "/")
 	parentLocation := strings.Join(locationSplit[:len(locationSplit)-1], "/")
 
 	// Check if parent directory exists
 	if dirExistence, err := gds.checkDirExistence(parentLocation); err != nil {
 		log.Default().Println(err.Error())
-		return err
+		return models.File{}, nil, err
 	} else if !dirExistence {
-		return apierrors.DirectoryNotFound{}
+		return models.File{}, nil, apierrors.DirectoryNotFound{}
+	}
+
+	// Uploading to the name of a file replaces its content
+	existingFile, err := gds.GetFileDetails(file.Location)
+	if err == nil {
+		return gds.AddFileVersion(existingFile, file, versionRetention)
+	}
+	if !errors.Is(err, apierrors.FileNotFound{}) {
+		return models.File{}, nil, err
 	}
 
 	// Checking whether no same names in directory
 	if sameFileNameExists, err := gds.checkForSameFileName(parentLocation, file.Name); err != nil {
 		log.Default().Println(err.Error())
-		return err
+		return models.File{}, nil, err
 	} else if sameFileNameExists {
-		return apierrors.FileWithSameNameAlreadyExists{}
+		return models.File{}, nil, apierrors.FileWithSameNameAlreadyExists{}
 	}
 
-	// Create file
+	// Create file, unless an item of the name was created meanwhile
 	createFileCypher := `
 		MATCH (d:Directory) WHERE d.location = $parentLocation
+		AND NOT EXISTS { MATCH (d)-[:CONTAINS]->(c) WHERE c.name = $name }
 		CREATE (d)-[:CONTAINS]->(newFile:File {
 			id: $id,
 			type: "file",
 			name: $name,
 			size: $size,
 			location: $location,
-			createdOn: $createdOn
+			createdOn: $createdOn,
+			modifiedOn: $createdOn,
+			version: 1,
+			sha256: $sha256,
+			storedSize: $storedSize,
+			uploadedBy: $uploadedBy,
+			mimeType: $mimeType
 		})
+		MERGE (b:Blob {workspace: $workspace, sha256: $sha256})
+		ON CREATE SET b.size = $size, b.storedSize = $storedSize, b.createdOn = $createdOn
+		CREATE (newFile)-[:STORED_AS]->(b)
+		RETURN newFile
 	`
 	createFileParams := map[string]any{
 		"parentLocation": parentLocation,
@@ -145,17 +170,26 @@ func (gds GraphDatabaseService) CreateFile(file models.File) error {
 		"size":           file.Size,
 		"location":       file.Location,
 		"createdOn":      file.CreatedOn,
+		"sha256":         file.Sha256,
+		"storedSize":     file.StoredSize,
+		"uploadedBy":     file.UploadedBy,
+		"mimeType":       file.MimeType,
+		"workspace":      locationSplit[0],
 	}
-	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+	createFileRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
 		createFileCypher, createFileParams,
 		neo4j.EagerResultTransformer,
 		neo4j.ExecuteQueryWithDatabase("neo4j"),
 	)
 	if err != nil {
 		log.Default().Println(err.Error())
-		return err
+		return models.File{}, nil, err
 	}
-	return nil
+	if len(createFileRes.Records) == 0 {
+		return models.File{}, nil, apierrors.FileWithSameNameAlreadyExists{}
+	}
+	fileRecord, _ := createFileRes.Records[0].Get("newFile")
+	return models.GetFileFromRecord(fileRecord), []models.File{}, nil
 }
 
 func (gds GraphDatabaseService) GetDirectoryDetails(location string) (models.Directory, error) {
@@ -228,6 +262,9 @@ func (gds GraphDatabaseService) GetFileDetails(fileLocation string) (models.File
 		log.Default().Println(err.Error())
 		return models.File{}, err
 	}
+	if len(getFileRes.Records) == 0 {
+		return models.File{}, apierrors.FileNotFound{}
+	}
 	fileRecord, found := getFileRes.Records[0].Get("f")
 	if !found {
 		return models.File{}, apierrors.FileNotFound{}
@@ -236,17 +273,72 @@ func (gds GraphDatabaseService) GetFileDetails(fileLocation string) (models.File
 	return file, nil
 }
 
-func (gds GraphDatabaseService) GetDirectoryAndContentDetails(dirLocation string) (models.DirectoryWithContents, error) {
+// GetDirectoryAndContentDetails lists a page of the directory. The filters,
+// ordering and paging are all done by the query, the counts are of every item
+// matching the filters.
+func (gds GraphDatabaseService) GetDirectoryAndContentDetails(dirLocation string, options models.ListingOptions) (models.DirectoryWithContents, error) {
+	sortKey, found := listingSortKeys[options.SortBy]
+	if !found {
+		options.SortBy = "name"
+		sortKey = listingSortKeys["name"]
+	}
+	direction, after := "ASC", ">"
+	if options.Descending {
+		direction, after = "DESC", "<"
+	}
+
+	getDirAndContentParams := map[string]any{
+		"dirLocation": dirLocation,
+		"type":        options.Type,
+		"namePrefix":  options.NamePrefix,
+		"tag":         options.Tag,
+		"cursorKind":  nil,
+		"cursorKey":   nil,
+		"cursorId":    "",
+		"fetchLimit":  options.Limit + 1,
+	}
+	if options.Cursor != "" {
+		cursor, err := parseCursor(options.Cursor, options.SortBy)
+		if err != nil {
+			return models.DirectoryWithContents{}, err
+		}
+		getDirAndContentParams["cursorKind"] = cursor.Kind
+		getDirAndContentParams["cursorKey"] = cursor.Key
+		getDirAndContentParams["cursorId"] = cursor.Id
+	}
+
+	filterCypher := `
+			($type = "" OR ($type = "file" AND c:File) OR ($type = "directory" AND c:Directory))
+			AND ($namePrefix = "" OR toLower(c.name) STARTS WITH toLower($namePrefix))
+			AND ($tag = "" OR $tag IN coalesce(c.tags, []))
+	`
+	// One more item than asked for tells whether there is a next page
+	limitCypher := ""
+	if options.Limit > 0 {
+		limitCypher = "LIMIT $fetchLimit"
+	}
 	getDirAndContentCypher := `
 		MATCH (parent:Directory) WHERE parent.location = $dirLocation
-		OPTIONAL MATCH (parent)-[:CONTAINS]->(c)
-		RETURN parent, collect(c) as content
+		CALL {
+			WITH parent
+			OPTIONAL MATCH (parent)-[:CONTAINS]->(c) WHERE ` + filterCypher + `
+			RETURN count(c) AS total, count(CASE WHEN c:Directory THEN 1 END) AS directories
+		}
+		CALL {
+			WITH parent
+			OPTIONAL MATCH (parent)-[:CONTAINS]->(c) WHERE ` + filterCypher + `
+			WITH c, CASE WHEN c:Directory THEN 0 ELSE 1 END AS kind, ` + sortKey + ` AS key
+			WHERE c IS NOT NULL AND ($cursorId = "" OR kind > $cursorKind OR (kind = $cursorKind AND
+				(key ` + after + ` $cursorKey OR (key = $cursorKey AND c.id ` + after + ` $cursorId))))
+			WITH c, kind, key
+			ORDER BY kind, key ` + direction + `, c.id ` + direction + `
+			` + limitCypher + `
+			RETURN collect(c) AS content
+		}
+		RETURN parent, total, directories, content
 	`
-	getDirAndContentCypherParams := map[string]any{
-		"dirLocation": dirLocation,
-	}
 	getDirAndContentRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
-		getDirAndContentCypher, getDirAndContentCypherParams,
+		getDirAndContentCypher, getDirAndContentParams,
 		neo4j.EagerResultTransformer,
 		neo4j.ExecuteQueryWithDatabase("neo4j"),
 	)
@@ -262,68 +354,138 @@ func (gds GraphDatabaseService) GetDirectoryAndContentDetails(dirLocation string
 		return models.DirectoryWithContents{}, apierrors.DirectoryNotFound{}
 	}
 	parent := models.GetDirectoryFromRecord(parentRecord)
-	record, found := getDirAndContentRes.Records[0].Get("content")
+	total, _ := getDirAndContentRes.Records[0].Get("total")
+	directories, _ := getDirAndContentRes.Records[0].Get("directories")
+	record, _ := getDirAndContentRes.Records[0].Get("content")
+	recordList := record.([]any)
+
+	nextCursor := ""
+	if options.Limit > 0 && len(recordList) > options.Limit {
+		recordList = recordList[:options.Limit]
+		nextCursor = cursorAfter(recordList[options.Limit-1].(neo4j.Node), options.SortBy)
+	}
 	contentList := []interface{}{}
-	if found {
-		recordList := record.([]any)
-		for _, r := range recordList {
-			label := r.(neo4j.Node).Labels[0]
-			if label == "Directory" {
-				contentList = append(contentList, models.GetDirectoryFromRecord(r))
-			} else if label == "File" {
-				contentList = append(contentList, models.GetFileFromRecord(r))
-			}
+	for _, r := range recordList {
+		if listingKind(r.(neo4j.Node)) == 0 {
+			contentList = append(contentList, models.GetDirectoryFromRecord(r))
+		} else {
+			contentList = append(contentList, models.GetFileFromRecord(r))
 		}
 	}
 
 	return models.DirectoryWithContents{
-		Id:        parent.Id,
-		Type:      "Directory",
-		Name:      parent.Name,
-		CreatedOn: parent.CreatedOn,
-		Location:  parent.Location,
-		Contents:  contentList,
+		Id:             parent.Id,
+		Type:           "Directory",
+		Name:           parent.Name,
+		CreatedOn:      parent.CreatedOn,
+		Location:       parent.Location,
+		Contents:       contentList,
+		TotalCount:     total.(int64),
+		DirectoryCount: directories.(int64),
+		FileCount:      total.(int64) - directories.(int64),
+		NextCursor:     nextCursor,
 	}, nil
 }
 
-func (gds GraphDatabaseService) DeleteDirectory(location string) error {
-	deleteDirCypher := `
-		MATCH (d:Directory) WHERE d.location = $location
-		DETACH DELETE d
+// deleteAndReleaseBlobs runs the delete query and drops the blobs of the
+// workspace that are left without any file. The query getting the files has to
+// return each file with its versions. It returns one deleted file for each
+// dropped blob, and every deleted file stored before blobs were shared, so the
+// caller can remove their content from storage.
+func (gds GraphDatabaseService) deleteAndReleaseBlobs(tx neo4j.ManagedTransaction, location string, getFilesCypher string, deleteCypher string, params map[string]any) ([]models.File, error) {
+	getFilesRes, err := tx.Run(gds.ctx, getFilesCypher, params)
+	if err != nil {
+		return nil, err
+	}
+	fileRecords, err := getFilesRes.Collect(gds.ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Run(gds.ctx, deleteCypher, params)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedFiles := []models.File{}
+	for _, fileRecord := range fileRecords {
+		fileNode, _ := fileRecord.Get("f")
+		file := models.GetFileFromRecord(fileNode)
+		deletedFiles = append(deletedFiles, file)
+		versionNodes, _ := fileRecord.Get("versions")
+		for _, versionNode := range versionNodes.([]any) {
+			deletedFiles = append(deletedFiles, file.WithVersion(models.GetFileVersionFromRecord(versionNode)))
+		}
+	}
+	return gds.releaseBlobs(tx, strings.Split(location, "/")[0], deletedFiles)
+}
+
+// releaseBlobs drops the blobs of the deleted files that are left without any
+// file or version, and picks the deleted files whose content has to be removed
+func (gds GraphDatabaseService) releaseBlobs(tx neo4j.ManagedTransaction, workspaceName string, deletedFiles []models.File) ([]models.File, error) {
+	deletedShas := []string{}
+	for _, file := range deletedFiles {
+		if file.Sha256 != "" {
+			deletedShas = append(deletedShas, file.Sha256)
+		}
+	}
+	releaseBlobsCypher := `
+		MATCH (b:Blob) WHERE b.workspace = $workspace AND b.sha256 IN $deletedShas AND NOT (b)<-[:STORED_AS]-()
+		WITH b, b.sha256 AS sha256
+		DETACH DELETE b
+		RETURN collect(sha256) AS released
 	`
-	deleteDirCypherParams := map[string]any{
-		"location": location,
+	releaseBlobsParams := map[string]any{
+		"workspace":   workspaceName,
+		"deletedShas": deletedShas,
 	}
-	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
-		deleteDirCypher, deleteDirCypherParams,
-		neo4j.EagerResultTransformer,
-		neo4j.ExecuteQueryWithDatabase("neo4j"),
-	)
+	releaseBlobsRes, err := tx.Run(gds.ctx, releaseBlobsCypher, releaseBlobsParams)
 	if err != nil {
-		log.Default().Println(err.Error())
-		return err
+		return nil, err
 	}
-	return nil
+	releaseBlobsRecord, err := releaseBlobsRes.Single(gds.ctx)
+	if err != nil {
+		return nil, err
+	}
+	releasedRecord, _ := releaseBlobsRecord.Get("released")
+	released := map[string]bool{}
+	for _, sha256 := range releasedRecord.([]any) {
+		released[sha256.(string)] = true
+	}
+
+	unreferencedFiles := []models.File{}
+	for _, file := range deletedFiles {
+		if file.Sha256 == "" {
+			unreferencedFiles = append(unreferencedFiles, file)
+		} else if released[file.Sha256] {
+			unreferencedFiles = append(unreferencedFiles, file)
+			delete(released, file.Sha256)
+		}
+	}
+	return unreferencedFiles, nil
 }
 
-func (gds GraphDatabaseService) DeleteFile(fileLocation string) error {
-	deleteFileCypher := `
-		MATCH (f:File) WHERE f.location = $fileLocation
-		DETACH DELETE f
+// IsBlobReferenced tells whether a file or version of the workspace is stored
+// as the content with the checksum
+func (gds GraphDatabaseService) IsBlobReferenced(workspaceName string, sha256 string) (bool, error) {
+	getBlobCypher := `
+		MATCH (b:Blob) WHERE b.workspace = $workspaceName AND b.sha256 = $sha256
+		RETURN count(b) AS count
 	`
-	deleteFileParams := map[string]any{
-		"fileLocation": fileLocation,
+	getBlobParams := map[string]any{
+		"workspaceName": workspaceName,
+		"sha256":        sha256,
 	}
-	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
-		deleteFileCypher, deleteFileParams,
+	getBlobRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getBlobCypher, getBlobParams,
 		neo4j.EagerResultTransformer,
 		neo4j.ExecuteQueryWithDatabase("neo4j"),
 	)
 	if err != nil {
 		log.Default().Println(err.Error())
-		return err
+		return false, err
 	}
-	return nil
+	count, _ := getBlobRes.Records[0].Get("count")
+	return count.(int64) != 0, nil
 }
 
 func (gds GraphDatabaseService) GetSharedDirsAndFiles(accId string, workspace string) ([]any, error) {
@@ -361,3 +523,317 @@ func (gds GraphDatabaseService) GetSharedDirsAndFiles(accId string, workspace st
 	}
 	return sharedList, nil
 }
+
+func (gds GraphDatabaseService) RenameDirectory(location string, newName string) (string, error) {
+	return gds.relocateItem("Directory", location, parentOf(location), newName)
+}
+
+func (gds GraphDatabaseService) RenameFile(location string, newName string) (string, error) {
+	return gds.relocateItem("File", location, parentOf(location), newName)
+}
+
+func (gds GraphDatabaseService) MoveDirectory(location string, destLocation string) (string, error) {
+	return gds.relocateItem("Directory", location, destLocation, nameOf(location))
+}
+
+func (gds GraphDatabaseService) MoveFile(location string, destLocation string) (string, error) {
+	return gds.relocateItem("File", location, destLocation, nameOf(location))
+}
+
+// relocateItem places the item under the destination directory with the given
+// name and rewrites the location of the item and of every node below it in a
+// single transaction. Returns the new location.
+func (gds GraphDatabaseService) relocateItem(label string, location string, destLocation string, newName string) (string, error) {
+	newLocation := destLocation + "/" + newName
+
+	// A directory cannot be placed inside itself
+	if destLocation == location || strings.HasPrefix(destLocation, location+"/") {
+		return "", apierrors.InvalidDestination{Location: destLocation}
+	}
+
+	_, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		// Checking whether the item and destination exist and no other item in the destination has the name
+		checkItemCypher := `
+			OPTIONAL MATCH (i:` + label + `) WHERE i.location = $location
+			OPTIONAL MATCH (d:Directory) WHERE d.location = $destLocation
+			OPTIONAL MATCH (d)-[:CONTAINS]->(s)
+			WHERE s.name = $newName AND s <> i
+			RETURN count(DISTINCT i) AS items, count(DISTINCT d) AS dirs, count(s) AS siblings
+		`
+		checkItemParams := map[string]any{
+			"location":     location,
+			"destLocation": destLocation,
+			"newName":      newName,
+		}
+		checkItemRes, err := tx.Run(gds.ctx, checkItemCypher, checkItemParams)
+		if err != nil {
+			return nil, err
+		}
+		checkItemRecord, err := checkItemRes.Single(gds.ctx)
+		if err != nil {
+			return nil, err
+		}
+		items, _ := checkItemRecord.Get("items")
+		dirs, _ := checkItemRecord.Get("dirs")
+		siblings, _ := checkItemRecord.Get("siblings")
+		if items.(int64) == 0 {
+			if label == "Directory" {
+				return nil, apierrors.DirectoryNotFound{}
+			}
+			return nil, apierrors.FileNotFound{}
+		}
+		if dirs.(int64) == 0 {
+			return nil, apierrors.DirectoryNotFound{DirName: destLocation}
+		}
+		if siblings.(int64) != 0 {
+			if label == "Directory" {
+				return nil, apierrors.DirectoryWithSameNameAlreadyExists{ParentDirName: destLocation, DirName: newName}
+			}
+			return nil, apierrors.FileWithSameNameAlreadyExists{ParentDirName: destLocation, FileName: newName}
+		}
+
+		// Re-pointing the CONTAINS edge when the parent changes
+		if destLocation != parentOf(location) {
+			moveItemCypher := `
+				MATCH (:Directory)-[c:CONTAINS]->(i:` + label + `) WHERE i.location = $location
+				MATCH (d:Directory) WHERE d.location = $destLocation
+				DELETE c
+				CREATE (d)-[:CONTAINS]->(i)
+			`
+			moveItemParams := map[string]any{
+				"location":     location,
+				"destLocation": destLocation,
+			}
+			_, err = tx.Run(gds.ctx, moveItemCypher, moveItemParams)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		relocateItemCypher := `
+			MATCH (i:` + label + `) WHERE i.location = $location
+			OPTIONAL MATCH (i)-[:CONTAINS*]->(c)
+			SET c.location = $newLocation + substring(c.location, size($location))
+			WITH DISTINCT i
+			SET i.name = $newName, i.location = $newLocation
+		`
+		relocateItemParams := map[string]any{
+			"location":    location,
+			"newLocation": newLocation,
+			"newName":     newName,
+		}
+		_, err = tx.Run(gds.ctx, relocateItemCypher, relocateItemParams)
+		return nil, err
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return "", err
+	}
+	return newLocation, nil
+}
+
+// GetSubtree returns the item at the location followed by every directory and
+// file below it, ordered by depth.
+func (gds GraphDatabaseService) GetSubtree(location string) ([]any, error) {
+	getSubtreeCypher := `
+		MATCH (top:Directory|File) WHERE top.location = $location
+		MATCH p=(top)-[:CONTAINS*0..]->(i)
+		RETURN i ORDER BY length(p)
+	`
+	getSubtreeParams := map[string]any{
+		"location": location,
+	}
+	getSubtreeRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getSubtreeCypher, getSubtreeParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	items := []any{}
+	for _, record := range getSubtreeRes.Records {
+		r, _ := record.Get("i")
+		label := r.(neo4j.Node).Labels[0]
+		if label == "Directory" {
+			items = append(items, models.GetDirectoryFromRecord(r))
+		} else if label == "File" {
+			items = append(items, models.GetFileFromRecord(r))
+		}
+	}
+	return items, nil
+}
+
+// CopyItems creates the copies under the destination directory in a single
+// transaction. The copies must be ordered so that parents come before their
+// contents and the first copy must be the top item placed in the destination.
+func (gds GraphDatabaseService) CopyItems(destLocation string, copies []models.ItemCopy) error {
+	if len(copies) == 0 {
+		return nil
+	}
+	_, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		// Checking whether the destination exists and has no item with the same name
+		checkDestCypher := `
+			OPTIONAL MATCH (d:Directory) WHERE d.location = $destLocation
+			OPTIONAL MATCH (d)-[:CONTAINS]->(s) WHERE s.name = $name
+			RETURN count(DISTINCT d) AS dirs, count(s) AS siblings
+		`
+		checkDestParams := map[string]any{
+			"destLocation": destLocation,
+			"name":         nameOf(copies[0].Location),
+		}
+		checkDestRes, err := tx.Run(gds.ctx, checkDestCypher, checkDestParams)
+		if err != nil {
+			return nil, err
+		}
+		checkDestRecord, err := checkDestRes.Single(gds.ctx)
+		if err != nil {
+			return nil, err
+		}
+		dirs, _ := checkDestRecord.Get("dirs")
+		siblings, _ := checkDestRecord.Get("siblings")
+		if dirs.(int64) == 0 {
+			return nil, apierrors.DirectoryNotFound{DirName: destLocation}
+		}
+		if siblings.(int64) != 0 {
+			return nil, apierrors.DirectoryWithSameNameAlreadyExists{ParentDirName: destLocation, DirName: nameOf(copies[0].Location)}
+		}
+
+		for _, itemCopy := range copies {
+			label := "File"
+			if itemCopy.Type == "directory" {
+				label = "Directory"
+			}
+			// Copying every property of the source and then giving the copy its own identity
+			copyItemCypher := `
+				MATCH (src:` + label + `) WHERE src.location = $sourceLocation
+				MATCH (p:Directory) WHERE p.location = $parentLocation
+				CREATE (p)-[:CONTAINS]->(n:` + label + `)
+				SET n = properties(src), n.id = $id, n.location = $location, n.name = $name, n.createdOn = $createdOn
+				WITH src, n
+				FOREACH (file IN CASE WHEN n:File THEN [n] ELSE [] END |
+					SET file.uploadedBy = $uploadedBy, file.version = 1, file.modifiedOn = $createdOn)
+				WITH src, n
+				OPTIONAL MATCH (src)-[:STORED_AS]->(b:Blob)
+				FOREACH (blob IN CASE WHEN b IS NULL THEN [] ELSE [b] END | CREATE (n)-[:STORED_AS]->(blob))
+			`
+			copyItemParams := map[string]any{
+				"sourceLocation": itemCopy.SourceLocation,
+				"parentLocation": parentOf(itemCopy.Location),
+				"id":             itemCopy.Id,
+				"location":       itemCopy.Location,
+				"name":           nameOf(itemCopy.Location),
+				"createdOn":      itemCopy.CreatedOn,
+				"uploadedBy":     itemCopy.UploadedBy,
+			}
+			_, err = tx.Run(gds.ctx, copyItemCypher, copyItemParams)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func parentOf(location string) string {
+	locationSplit := strings.Split(location, "/")
+	return strings.Join(locationSplit[:len(locationSplit)-1], "/")
+}
+
+func nameOf(location string) string {
+	locationSplit := strings.Split(location, "/")
+	return locationSplit[len(locationSplit)-1]
+}
+
+// GetReferencedBlobKeys returns the blob key of every file and file version,
+// including those in the trash.
+// Files with a checksum share the blob of their content, older ones have their
+// own.
+func (gds GraphDatabaseService) GetReferencedBlobKeys() (map[string]bool, error) {
+	getKeysCypher := `
+		MATCH (f:File|TrashedFile)
+		WITH split(f.location, "/")[0] AS workspace,
+			CASE WHEN coalesce(f.sha256, "") = "" THEN f.id ELSE f.sha256 END AS name
+		RETURN DISTINCT workspace + "/" + name AS key
+		UNION
+		MATCH (f:File|TrashedFile)-[:PREVIOUS_VERSION*]->(v:FileVersion)
+		WITH split(f.location, "/")[0] AS workspace,
+			CASE WHEN coalesce(v.sha256, "") = "" THEN f.id ELSE v.sha256 END AS name
+		RETURN DISTINCT workspace + "/" + name AS key
+	`
+	getKeysRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getKeysCypher, map[string]any{},
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, record := range getKeysRes.Records {
+		key, _ := record.Get("key")
+		keys[key.(string)] = true
+	}
+	return keys, nil
+}
+
+// GetTree returns the directory and the items below it down to the depth,
+// parents before their items. Every directory comes with the counts and the
+// size of its direct items.
+func (gds GraphDatabaseService) GetTree(location string, depth int) ([]models.TreeNode, error) {
+	// Bounds of a variable length pattern cannot be parameters
+	getTreeCypher := `
+		MATCH (top:Directory) WHERE top.location = $location
+		MATCH p=(top)-[:CONTAINS*0..` + strconv.Itoa(depth) + `]->(i)
+		OPTIONAL MATCH (i)-[:CONTAINS]->(c)
+		RETURN i, length(p) AS depth,
+			count(CASE WHEN c:Directory THEN 1 END) AS directories,
+			count(CASE WHEN c:File THEN 1 END) AS files,
+			coalesce(sum(c.size), 0) AS bytes
+		ORDER BY depth, CASE WHEN i:Directory THEN 0 ELSE 1 END, i.name
+	`
+	getTreeParams := map[string]any{
+		"location": location,
+	}
+	getTreeRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getTreeCypher, getTreeParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	if len(getTreeRes.Records) == 0 {
+		return nil, apierrors.DirectoryNotFound{}
+	}
+	nodes := []models.TreeNode{}
+	for _, record := range getTreeRes.Records {
+		r, _ := record.Get("i")
+		itemDepth, _ := record.Get("depth")
+		node := models.TreeNode{
+			Children: []*models.TreeNode{},
+			Depth:    int(itemDepth.(int64)),
+		}
+		if listingKind(r.(neo4j.Node)) == 0 {
+			directories, _ := record.Get("directories")
+			files, _ := record.Get("files")
+			bytes, _ := record.Get("bytes")
+			node.Item = models.GetDirectoryFromRecord(r)
+			node.Directories = directories.(int64)
+			node.Files = files.(int64)
+			node.Bytes = bytes.(int64)
+		} else {
+			node.Item = models.GetFileFromRecord(r)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
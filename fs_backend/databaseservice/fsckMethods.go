@@ -0,0 +1,195 @@
+package databaseservice
+
+import (
+	"fs_backend/models"
+	"log"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// GetLocationMismatches walks the workspace from its root directory and
+// returns the items whose location does not follow the path to them
+func (gds GraphDatabaseService) GetLocationMismatches(workspaceName string) ([]models.LocationMismatch, error) {
+	getMismatchesCypher := `
+		MATCH p=(root:RootDirectory)-[:CONTAINS*]->(i)
+		WHERE root.location = $workspaceName
+		WITH i, reduce(path = root.location, n IN tail(nodes(p)) | path + "/" + n.name) AS pathLocation
+		WHERE i.location <> pathLocation
+		RETURN i.id AS id, i.location AS location, pathLocation
+		ORDER BY pathLocation
+	`
+	getMismatchesParams := map[string]any{
+		"workspaceName": workspaceName,
+	}
+	getMismatchesRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getMismatchesCypher, getMismatchesParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	mismatches := []models.LocationMismatch{}
+	for _, record := range getMismatchesRes.Records {
+		id, _ := record.Get("id")
+		location, _ := record.Get("location")
+		pathLocation, _ := record.Get("pathLocation")
+		mismatch := models.LocationMismatch{
+			Id:           id.(string),
+			PathLocation: pathLocation.(string),
+		}
+		// An item can be missing its location altogether
+		mismatch.Location, _ = location.(string)
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, nil
+}
+
+// FixItemLocation sets the location of the item to the one of its path. It
+// is left alone when another item already has that location.
+func (gds GraphDatabaseService) FixItemLocation(mismatch models.LocationMismatch) (bool, error) {
+	fixLocationCypher := `
+		MATCH (i:Directory|File) WHERE i.id = $id
+		AND NOT EXISTS { MATCH (o:Directory|File) WHERE o.location = $pathLocation }
+		SET i.location = $pathLocation
+		RETURN count(i) AS count
+	`
+	fixLocationParams := map[string]any{
+		"id":           mismatch.Id,
+		"pathLocation": mismatch.PathLocation,
+	}
+	fixLocationRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		fixLocationCypher, fixLocationParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return false, err
+	}
+	count, _ := fixLocationRes.Records[0].Get("count")
+	return count.(int64) != 0, nil
+}
+
+// GetDanglingRoleEdges returns MANAGES edges of the workspace's roles to items
+// outside its tree, and of other roles to items inside it
+func (gds GraphDatabaseService) GetDanglingRoleEdges(workspaceName string) ([]models.DanglingRoleEdge, error) {
+	getEdgesCypher := `
+		MATCH (r:Role)-[m:MANAGES]->(i) WHERE NOT i:TrashedFile AND NOT i:TrashedDirectory
+		OPTIONAL MATCH (r)-[:ROLLED_IN]->(w:Workspace)
+		WITH r, m, i, w, EXISTS {
+			MATCH (root:RootDirectory)-[:CONTAINS*0..]->(i) WHERE root.location = $workspaceName
+		} AS inTree
+		WHERE (w.name = $workspaceName AND NOT inTree) OR (inTree AND (w IS NULL OR w.name <> $workspaceName))
+		RETURN elementId(m) AS edgeId, r.name AS roleName, i.location AS location
+		ORDER BY location
+	`
+	getEdgesParams := map[string]any{
+		"workspaceName": workspaceName,
+	}
+	getEdgesRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getEdgesCypher, getEdgesParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	edges := []models.DanglingRoleEdge{}
+	for _, record := range getEdgesRes.Records {
+		edgeId, _ := record.Get("edgeId")
+		roleName, _ := record.Get("roleName")
+		location, _ := record.Get("location")
+		edge := models.DanglingRoleEdge{
+			EdgeId: edgeId.(string),
+		}
+		edge.RoleName, _ = roleName.(string)
+		edge.Location, _ = location.(string)
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+func (gds GraphDatabaseService) DeleteRoleEdge(edgeId string) error {
+	deleteEdgeCypher := `
+		MATCH (:Role)-[m:MANAGES]->() WHERE elementId(m) = $edgeId
+		DELETE m
+	`
+	deleteEdgeParams := map[string]any{
+		"edgeId": edgeId,
+	}
+	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		deleteEdgeCypher, deleteEdgeParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetDuplicateNames returns the names used by more than one item of a
+// directory in the workspace
+func (gds GraphDatabaseService) GetDuplicateNames(workspaceName string) ([]models.DuplicateName, error) {
+	getDuplicatesCypher := `
+		MATCH (root:RootDirectory)-[:CONTAINS*0..]->(d:Directory)-[:CONTAINS]->(c)
+		WHERE root.location = $workspaceName
+		WITH DISTINCT d, c
+		WITH d, c.name AS name, count(c) AS count
+		WHERE count > 1
+		RETURN d.location AS location, name, count
+		ORDER BY location, name
+	`
+	getDuplicatesParams := map[string]any{
+		"workspaceName": workspaceName,
+	}
+	getDuplicatesRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getDuplicatesCypher, getDuplicatesParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	duplicates := []models.DuplicateName{}
+	for _, record := range getDuplicatesRes.Records {
+		location, _ := record.Get("location")
+		name, _ := record.Get("name")
+		count, _ := record.Get("count")
+		duplicate := models.DuplicateName{
+			Count: int(count.(int64)),
+		}
+		duplicate.Location, _ = location.(string)
+		duplicate.Name, _ = name.(string)
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates, nil
+}
+
+func (gds GraphDatabaseService) SetFileStoredSize(fileId string, storedSize int64) error {
+	setStoredSizeCypher := `
+		MATCH (f:File) WHERE f.id = $fileId
+		OPTIONAL MATCH (f)-[:STORED_AS]->(b:Blob)
+		SET f.storedSize = $storedSize
+		FOREACH (blob IN CASE WHEN b IS NULL THEN [] ELSE [b] END | SET blob.storedSize = $storedSize)
+	`
+	setStoredSizeParams := map[string]any{
+		"fileId":     fileId,
+		"storedSize": storedSize,
+	}
+	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		setStoredSizeCypher, setStoredSizeParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
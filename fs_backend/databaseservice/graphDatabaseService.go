@@ -47,9 +47,30 @@ func (gds *GraphDatabaseService) Connect() {
 		return
 	}
 	log.Default().Println("Connected to Neo4j server at", uri)
+
+	err = gds.createIndexes()
+	if err != nil {
+		log.Default().Println("Error creating Neo4j indexes : ", err.Error())
+	}
 }
 
 func (gds *GraphDatabaseService) Close() {
 	gds.driver.Close(gds.ctx)
 	gds.ctx.Done()
 }
+
+// executeWrite runs the work inside a single write transaction so that
+// multi-statement updates are either fully applied or not at all.
+func (gds GraphDatabaseService) executeWrite(work neo4j.ManagedTransactionWork) (any, error) {
+	session := gds.driver.NewSession(gds.ctx, neo4j.SessionConfig{DatabaseName: "neo4j"})
+	defer session.Close(gds.ctx)
+	return session.ExecuteWrite(gds.ctx, work)
+}
+
+// executeRead runs the work inside a single read transaction, so that every
+// statement reads the same state
+func (gds GraphDatabaseService) executeRead(work neo4j.ManagedTransactionWork) (any, error) {
+	session := gds.driver.NewSession(gds.ctx, neo4j.SessionConfig{DatabaseName: "neo4j"})
+	defer session.Close(gds.ctx)
+	return session.ExecuteRead(gds.ctx, work)
+}
@@ -0,0 +1,95 @@
+package databaseservice
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"fs_backend/apierrors"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Expressions ordering the items of a listing, by the sort option. The item
+// is bound to c.
+var listingSortKeys = map[string]string{
+	"name":      "c.name",
+	"size":      "coalesce(c.size, 0)",
+	"createdOn": "c.createdOn",
+}
+
+// listingCursor points at the last item of a page. The next page starts after
+// it, so items added or removed meanwhile do not shift the pages.
+type listingCursor struct {
+	Kind int64  `json:"k"`
+	Key  any    `json:"v"`
+	Id   string `json:"i"`
+}
+
+// listingKind orders directories before files
+func listingKind(node neo4j.Node) int64 {
+	for _, label := range node.Labels {
+		if label == "Directory" {
+			return 0
+		}
+	}
+	return 1
+}
+
+// cursorAfter makes the cursor of the page ending with the node
+func cursorAfter(node neo4j.Node, sortBy string) string {
+	cursor := listingCursor{
+		Kind: listingKind(node),
+		Id:   node.Props["id"].(string),
+	}
+	switch sortBy {
+	case "size":
+		size, _ := node.Props["size"].(int64)
+		cursor.Key = size
+	case "createdOn":
+		cursor.Key = node.Props["createdOn"].(time.Time).Format(time.RFC3339Nano)
+	default:
+		cursor.Key = node.Props["name"].(string)
+	}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// parseCursor reads a cursor made by cursorAfter for the same sort order
+func parseCursor(encoded string, sortBy string) (listingCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return listingCursor{}, apierrors.InvalidListingCursor{}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+	var cursor listingCursor
+	if err = decoder.Decode(&cursor); err != nil || cursor.Id == "" {
+		return listingCursor{}, apierrors.InvalidListingCursor{}
+	}
+
+	switch sortBy {
+	case "size":
+		number, isNumber := cursor.Key.(json.Number)
+		if !isNumber {
+			return listingCursor{}, apierrors.InvalidListingCursor{}
+		}
+		if cursor.Key, err = number.Int64(); err != nil {
+			return listingCursor{}, apierrors.InvalidListingCursor{}
+		}
+	case "createdOn":
+		value, isString := cursor.Key.(string)
+		if !isString {
+			return listingCursor{}, apierrors.InvalidListingCursor{}
+		}
+		if cursor.Key, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return listingCursor{}, apierrors.InvalidListingCursor{}
+		}
+	default:
+		if _, isString := cursor.Key.(string); !isString {
+			return listingCursor{}, apierrors.InvalidListingCursor{}
+		}
+	}
+	return cursor, nil
+}
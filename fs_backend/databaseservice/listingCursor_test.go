@@ -0,0 +1,91 @@
+package databaseservice
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"fs_backend/apierrors"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+func TestListingCursorRoundTrip(t *testing.T) {
+	createdOn := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	file := neo4j.Node{
+		Labels: []string{"File"},
+		Props:  map[string]any{"id": "file-id", "name": "report.pdf", "size": int64(2048), "createdOn": createdOn},
+	}
+	directory := neo4j.Node{
+		Labels: []string{"Directory"},
+		Props:  map[string]any{"id": "dir-id", "name": "docs", "createdOn": createdOn},
+	}
+
+	tests := []struct {
+		name   string
+		node   neo4j.Node
+		sortBy string
+		kind   int64
+		key    any
+	}{
+		{name: "file by name", node: file, sortBy: "name", kind: 1, key: "report.pdf"},
+		{name: "file by size", node: file, sortBy: "size", kind: 1, key: int64(2048)},
+		{name: "file by creation", node: file, sortBy: "createdOn", kind: 1, key: createdOn},
+		{name: "directory by name", node: directory, sortBy: "name", kind: 0, key: "docs"},
+		// Directories have no size, so they sort as empty
+		{name: "directory by size", node: directory, sortBy: "size", kind: 0, key: int64(0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor, err := parseCursor(cursorAfter(test.node, test.sortBy), test.sortBy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cursor.Kind != test.kind || cursor.Id != test.node.Props["id"] {
+				t.Errorf("got kind %d and id %q, want %d and %q", cursor.Kind, cursor.Id, test.kind, test.node.Props["id"])
+			}
+			if createdOn, isTime := test.key.(time.Time); isTime {
+				if got, _ := cursor.Key.(time.Time); !got.Equal(createdOn) {
+					t.Errorf("got key %v, want %v", cursor.Key, createdOn)
+				}
+				return
+			}
+			if cursor.Key != test.key {
+				t.Errorf("got key %v (%T), want %v (%T)", cursor.Key, cursor.Key, test.key, test.key)
+			}
+		})
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	encode := func(cursor any) string {
+		encoded, _ := json.Marshal(cursor)
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		sortBy string
+	}{
+		{name: "not base64", cursor: "not base64!", sortBy: "name"},
+		{name: "not json", cursor: base64.RawURLEncoding.EncodeToString([]byte("cursor")), sortBy: "name"},
+		{name: "no id", cursor: encode(map[string]any{"k": 1, "v": "a"}), sortBy: "name"},
+		{name: "number for a name", cursor: encode(map[string]any{"k": 1, "v": 5, "i": "id"}), sortBy: "name"},
+		{name: "name for a size", cursor: encode(map[string]any{"k": 1, "v": "a", "i": "id"}), sortBy: "size"},
+		{name: "fraction for a size", cursor: encode(map[string]any{"k": 1, "v": 1.5, "i": "id"}), sortBy: "size"},
+		{name: "number for a time", cursor: encode(map[string]any{"k": 1, "v": 5, "i": "id"}), sortBy: "createdOn"},
+		{name: "name for a time", cursor: encode(map[string]any{"k": 1, "v": "a", "i": "id"}), sortBy: "createdOn"},
+		{name: "size cursor sorting by name", cursor: cursorAfter(neo4j.Node{Props: map[string]any{"id": "id", "size": int64(1)}}, "size"), sortBy: "name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCursor(test.cursor, test.sortBy)
+			if !errors.As(err, &apierrors.InvalidListingCursor{}) {
+				t.Errorf("got error %v, want an invalid cursor", err)
+			}
+		})
+	}
+}
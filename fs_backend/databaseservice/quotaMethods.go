@@ -0,0 +1,257 @@
+package databaseservice
+
+import (
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Reservations left behind by a server that stopped while committing are
+// ignored after this long
+const quotaReservationLifetime = time.Hour
+
+// quotaState holds the quotas of a workspace and of an account, with what
+// commits in progress have reserved of them
+type quotaState struct {
+	quotaBytes           int64
+	quotaFiles           int64
+	accountQuotaBytes    int64
+	reservedBytes        int64
+	reservedFiles        int64
+	accountReservedBytes int64
+}
+
+func (qs quotaState) hasQuota() bool {
+	return qs.quotaBytes != 0 || qs.quotaFiles != 0 || qs.accountQuotaBytes != 0
+}
+
+// CheckQuota fails with QuotaExceeded when the bytes and files would not fit in
+// the quotas of the workspace or of the account, next to what is stored and
+// reserved. It holds no space, so it only turns away what cannot fit early on.
+func (gds GraphDatabaseService) CheckQuota(workspaceName string, accountId string, addedBytes int64, addedFiles int64) error {
+	_, err := gds.executeRead(func(tx neo4j.ManagedTransaction) (any, error) {
+		state, err := gds.readQuotas(tx, workspaceName, accountId)
+		if err != nil || !state.hasQuota() {
+			return nil, err
+		}
+		reservedCypher := `
+			MATCH (w:Workspace)-[:HAS_RESERVATION]->(r:QuotaReservation)
+			WHERE w.name = $workspaceName AND r.createdOn >= $staleBefore
+			RETURN coalesce(sum(r.bytes), 0) AS reservedBytes,
+				coalesce(sum(r.files), 0) AS reservedFiles,
+				coalesce(sum(CASE WHEN r.accountId = $accountId THEN r.bytes ELSE 0 END), 0) AS accountReservedBytes
+		`
+		reservedParams := map[string]any{
+			"workspaceName": workspaceName,
+			"accountId":     accountId,
+			"staleBefore":   time.Now().UTC().Add(-quotaReservationLifetime),
+		}
+		err = gds.readReserved(tx, reservedCypher, reservedParams, &state)
+		if err != nil {
+			return nil, err
+		}
+		return nil, gds.checkQuotaState(tx, state, workspaceName, accountId, addedBytes, addedFiles)
+	})
+	return err
+}
+
+// ReserveQuota checks that the bytes and files of the reservation fit in the
+// quotas of the workspace and of the account, counting what is reserved by
+// commits in progress, and reserves them until ReleaseQuota. The workspace is
+// locked while checking, so concurrent commits cannot take the same space.
+// Nothing is reserved when there is no quota, which it reports.
+func (gds GraphDatabaseService) ReserveQuota(reservation models.QuotaReservation) (bool, error) {
+	reserved, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		state, err := gds.readQuotas(tx, reservation.WorkspaceName, reservation.AccountId)
+		if err != nil || !state.hasQuota() {
+			return false, err
+		}
+
+		// Writing to the workspace locks it until the transaction ends. Stale
+		// reservations are dropped meanwhile.
+		lockCypher := `
+			MATCH (w:Workspace) WHERE w.name = $workspaceName
+			SET w.quotaCheckedOn = $now
+			WITH w
+			OPTIONAL MATCH (w)-[:HAS_RESERVATION]->(r:QuotaReservation)
+			WITH w, r, r IS NOT NULL AND r.createdOn < $staleBefore AS isStale
+			FOREACH (stale IN CASE WHEN isStale THEN [r] ELSE [] END | DETACH DELETE stale)
+			WITH w, CASE WHEN isStale THEN null ELSE r END AS live
+			RETURN coalesce(sum(live.bytes), 0) AS reservedBytes,
+				coalesce(sum(live.files), 0) AS reservedFiles,
+				coalesce(sum(CASE WHEN live.accountId = $accountId THEN live.bytes ELSE 0 END), 0) AS accountReservedBytes
+		`
+		lockParams := map[string]any{
+			"workspaceName": reservation.WorkspaceName,
+			"accountId":     reservation.AccountId,
+			"now":           reservation.CreatedOn,
+			"staleBefore":   reservation.CreatedOn.Add(-quotaReservationLifetime),
+		}
+		err = gds.readReserved(tx, lockCypher, lockParams, &state)
+		if err != nil {
+			return false, err
+		}
+
+		// Usage is read under the lock, so it holds everything committed before
+		err = gds.checkQuotaState(tx, state, reservation.WorkspaceName, reservation.AccountId, reservation.Bytes, reservation.Files)
+		if err != nil {
+			return false, err
+		}
+
+		reserveCypher := `
+			MATCH (w:Workspace) WHERE w.name = $workspaceName
+			CREATE (w)-[:HAS_RESERVATION]->(:QuotaReservation {
+				id: $id,
+				accountId: $accountId,
+				bytes: $bytes,
+				files: $files,
+				createdOn: $createdOn
+			})
+		`
+		reserveParams := map[string]any{
+			"workspaceName": reservation.WorkspaceName,
+			"id":            reservation.Id,
+			"accountId":     reservation.AccountId,
+			"bytes":         reservation.Bytes,
+			"files":         reservation.Files,
+			"createdOn":     reservation.CreatedOn,
+		}
+		_, err = tx.Run(gds.ctx, reserveCypher, reserveParams)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return reserved.(bool), nil
+}
+
+// readQuotas reads the quotas of the workspace and of the account, which has
+// none when it is not a service account of the workspace
+func (gds GraphDatabaseService) readQuotas(tx neo4j.ManagedTransaction, workspaceName string, accountId string) (quotaState, error) {
+	getQuotasCypher := `
+		MATCH (w:Workspace) WHERE w.name = $workspaceName
+		OPTIONAL MATCH (sa:ServiceAccount)-[:SERVICES]->(w) WHERE sa.id = $accountId
+		RETURN coalesce(w.quotaBytes, 0) AS quotaBytes,
+			coalesce(w.quotaFiles, 0) AS quotaFiles,
+			coalesce(sa.quotaBytes, 0) AS accountQuotaBytes
+	`
+	getQuotasParams := map[string]any{
+		"workspaceName": workspaceName,
+		"accountId":     accountId,
+	}
+	getQuotasRes, err := tx.Run(gds.ctx, getQuotasCypher, getQuotasParams)
+	if err != nil {
+		return quotaState{}, err
+	}
+	getQuotasRecords, err := getQuotasRes.Collect(gds.ctx)
+	if err != nil {
+		return quotaState{}, err
+	}
+	if len(getQuotasRecords) == 0 {
+		return quotaState{}, apierrors.WorkspaceNotFound{}
+	}
+	quotaBytes, _ := getQuotasRecords[0].Get("quotaBytes")
+	quotaFiles, _ := getQuotasRecords[0].Get("quotaFiles")
+	accountQuotaBytes, _ := getQuotasRecords[0].Get("accountQuotaBytes")
+	return quotaState{
+		quotaBytes:        quotaBytes.(int64),
+		quotaFiles:        quotaFiles.(int64),
+		accountQuotaBytes: accountQuotaBytes.(int64),
+	}, nil
+}
+
+// readReserved runs a query adding up the reservations into the state
+func (gds GraphDatabaseService) readReserved(tx neo4j.ManagedTransaction, cypher string, params map[string]any, state *quotaState) error {
+	reservedRes, err := tx.Run(gds.ctx, cypher, params)
+	if err != nil {
+		return err
+	}
+	reservedRecord, err := reservedRes.Single(gds.ctx)
+	if err != nil {
+		return err
+	}
+	reservedBytes, _ := reservedRecord.Get("reservedBytes")
+	reservedFiles, _ := reservedRecord.Get("reservedFiles")
+	accountReservedBytes, _ := reservedRecord.Get("accountReservedBytes")
+	state.reservedBytes = reservedBytes.(int64)
+	state.reservedFiles = reservedFiles.(int64)
+	state.accountReservedBytes = accountReservedBytes.(int64)
+	return nil
+}
+
+// checkQuotaState reads the usage the quotas apply to and fails with
+// QuotaExceeded when the added bytes and files do not fit next to it
+func (gds GraphDatabaseService) checkQuotaState(tx neo4j.ManagedTransaction, state quotaState, workspaceName string, accountId string, addedBytes int64, addedFiles int64) error {
+	if state.quotaBytes != 0 || state.quotaFiles != 0 {
+		usage, err := gds.readWorkspaceUsage(tx, workspaceName, "")
+		if err != nil {
+			return err
+		}
+		if exceedsQuota(usage.Bytes+state.reservedBytes+addedBytes, state.quotaBytes) ||
+			exceedsQuota(usage.Files+state.reservedFiles+addedFiles, state.quotaFiles) {
+			return apierrors.QuotaExceeded{WorkspaceName: workspaceName}
+		}
+	}
+	if state.accountQuotaBytes != 0 {
+		usage, err := gds.readWorkspaceUsage(tx, workspaceName, accountId)
+		if err != nil {
+			return err
+		}
+		if exceedsQuota(usage.Bytes+state.accountReservedBytes+addedBytes, state.accountQuotaBytes) {
+			return apierrors.QuotaExceeded{WorkspaceName: workspaceName}
+		}
+	}
+	return nil
+}
+
+// ReleaseQuota gives back the space held by the reservation. Once the record
+// of the content is created, the space is counted as usage instead.
+func (gds GraphDatabaseService) ReleaseQuota(reservation models.QuotaReservation) error {
+	releaseCypher := `
+		MATCH (w:Workspace)-[:HAS_RESERVATION]->(r:QuotaReservation)
+		WHERE w.name = $workspaceName AND r.id = $id
+		DETACH DELETE r
+	`
+	releaseParams := map[string]any{
+		"workspaceName": reservation.WorkspaceName,
+		"id":            reservation.Id,
+	}
+	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		releaseCypher, releaseParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	return err
+}
+
+// readWorkspaceUsage is GetWorkspaceUsage within the transaction
+func (gds GraphDatabaseService) readWorkspaceUsage(tx neo4j.ManagedTransaction, workspaceName string, accountId string) (models.WorkspaceUsage, error) {
+	getUsageParams := map[string]any{
+		"workspaceName": workspaceName,
+		"accountId":     accountId,
+	}
+	getUsageRes, err := tx.Run(gds.ctx, workspaceUsageCypher, getUsageParams)
+	if err != nil {
+		return models.WorkspaceUsage{}, err
+	}
+	getUsageRecord, err := getUsageRes.Single(gds.ctx)
+	if err != nil {
+		return models.WorkspaceUsage{}, err
+	}
+	files, _ := getUsageRecord.Get("files")
+	bytes, _ := getUsageRecord.Get("bytes")
+	return models.WorkspaceUsage{
+		Files: files.(int64),
+		Bytes: bytes.(int64),
+	}, nil
+}
+
+func exceedsQuota(total int64, quota int64) bool {
+	return quota != 0 && total > quota
+}
@@ -441,3 +441,70 @@ func (gds GraphDatabaseService) GetAllRolesAccount(accountId string) ([]models.R
 	}
 	return roles, nil
 }
+
+// GetAccountRolesInSubtree returns the roles of the account attached to the
+// item at the location or any item below it, keyed by the item location.
+func (gds GraphDatabaseService) GetAccountRolesInSubtree(accountId string, location string) (map[string][]models.Role, error) {
+	getRolesCypher := `
+		MATCH (top:Directory|File) WHERE top.location = $location
+		MATCH (top)-[:CONTAINS*0..]->(i)<-[:MANAGES]-(r:Role)<-[:HAS_ROLE]-(:ServiceAccount{id: $accountId})
+		RETURN i.location AS location, collect(DISTINCT r) AS roles
+	`
+	getRolesCypherParams := map[string]any{
+		"accountId": accountId,
+		"location":  location,
+	}
+	recordsRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getRolesCypher, getRolesCypherParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	assignments := map[string][]models.Role{}
+	for _, record := range recordsRes.Records {
+		itemLocation, _ := record.Get("location")
+		rolesRecord, _ := record.Get("roles")
+		roles := []models.Role{}
+		for _, roleRecord := range rolesRecord.([]any) {
+			roles = append(roles, models.GetRoleFromRecord(roleRecord))
+		}
+		assignments[itemLocation.(string)] = roles
+	}
+	return assignments, nil
+}
+
+// GetRoleAttachmentsInSubtree returns every MANAGES attachment on the item at
+// the location or any item below it.
+func (gds GraphDatabaseService) GetRoleAttachmentsInSubtree(location string) ([]models.RoleAttachment, error) {
+	getAttachmentsCypher := `
+		MATCH (top:Directory|File) WHERE top.location = $location
+		MATCH (top)-[:CONTAINS*0..]->(i)<-[:MANAGES]-(r:Role)
+		RETURN r, i.location AS location
+		ORDER BY location
+	`
+	getAttachmentsCypherParams := map[string]any{
+		"location": location,
+	}
+	recordsRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getAttachmentsCypher, getAttachmentsCypherParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	attachments := []models.RoleAttachment{}
+	for _, record := range recordsRes.Records {
+		roleRecord, _ := record.Get("r")
+		itemLocation, _ := record.Get("location")
+		attachments = append(attachments, models.RoleAttachment{
+			Role:     models.GetRoleFromRecord(roleRecord),
+			Location: itemLocation.(string),
+		})
+	}
+	return attachments, nil
+}
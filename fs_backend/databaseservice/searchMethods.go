@@ -0,0 +1,180 @@
+package databaseservice
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"fs_backend/models"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Characters with a meaning in full-text queries, escaped so that content is
+// searched for the words as typed
+var fullTextSpecialChars = regexp.MustCompile(`[+\-&|!(){}\[\]^"~*?:\\/]`)
+
+// globToRegex turns a name pattern with * and ? into a case insensitive
+// regular expression matching the whole name
+func globToRegex(glob string) string {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\?`, `.`)
+	return "(?i)" + quoted
+}
+
+// SearchItems returns the items below the location matching the options,
+// ordered by location. Searching by content only finds files whose content
+// has been indexed.
+func (gds GraphDatabaseService) SearchItems(options models.SearchOptions) ([]any, error) {
+	searchParams := map[string]any{
+		"locationPrefix": options.Location + "/",
+		"after":          options.After,
+		"type":           options.Type,
+		"name":           options.Name,
+		"namePattern":    globToRegex(options.Name),
+		"extension":      strings.ToLower(options.Extension),
+		"minSize":        nil,
+		"maxSize":        nil,
+		"from":           nil,
+		"to":             nil,
+		"uploadedBy":     options.UploadedBy,
+		"tag":            options.Tag,
+		"metadataKey":    "",
+		"metadataValue":  options.MetadataValue,
+		"content":        fullTextSpecialChars.ReplaceAllString(options.Content, `\$0`),
+		"limit":          options.Limit,
+	}
+
+	if options.MetadataKey != "" {
+		searchParams["metadataKey"] = models.MetadataPropertyPrefix + options.MetadataKey
+	}
+
+	// Unset bounds are passed as null
+	if options.MinSize != nil {
+		searchParams["minSize"] = *options.MinSize
+	}
+	if options.MaxSize != nil {
+		searchParams["maxSize"] = *options.MaxSize
+	}
+	if options.From != nil {
+		searchParams["from"] = *options.From
+	}
+	if options.To != nil {
+		searchParams["to"] = *options.To
+	}
+
+	nameCypher := `toLower(i.name) STARTS WITH toLower($name)`
+	switch options.Match {
+	case "substring":
+		nameCypher = `toLower(i.name) CONTAINS toLower($name)`
+	case "glob":
+		nameCypher = `i.name =~ $namePattern`
+	}
+	matchCypher := `MATCH (i:Directory|File) WHERE i.location STARTS WITH $locationPrefix`
+	if options.Content != "" {
+		matchCypher = `
+			CALL db.index.fulltext.queryNodes("blobText", $content) YIELD node AS b
+			MATCH (i:File)-[:STORED_AS]->(b) WHERE i.location STARTS WITH $locationPrefix
+		`
+	}
+	searchCypher := matchCypher + `
+			AND i.location > $after
+			AND ($type = "" OR ($type = "file" AND i:File) OR ($type = "directory" AND i:Directory))
+			AND ($name = "" OR ` + nameCypher + `)
+			AND ($extension = "" OR toLower(i.name) ENDS WITH "." + $extension)
+			AND ($minSize IS NULL OR (i:File AND i.size >= $minSize))
+			AND ($maxSize IS NULL OR (i:File AND i.size <= $maxSize))
+			AND ($from IS NULL OR coalesce(i.modifiedOn, i.createdOn) >= $from)
+			AND ($to IS NULL OR coalesce(i.modifiedOn, i.createdOn) <= $to)
+			AND ($uploadedBy = "" OR i.uploadedBy = $uploadedBy)
+			AND ($tag = "" OR $tag IN coalesce(i.tags, []))
+			AND ($metadataKey = "" OR i[$metadataKey] = $metadataValue)
+		RETURN DISTINCT i
+		ORDER BY i.location
+		LIMIT $limit
+	`
+	searchRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		searchCypher, searchParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	items := []any{}
+	for _, record := range searchRes.Records {
+		r, _ := record.Get("i")
+		if listingKind(r.(neo4j.Node)) == 0 {
+			items = append(items, models.GetDirectoryFromRecord(r))
+		} else {
+			items = append(items, models.GetFileFromRecord(r))
+		}
+	}
+	return items, nil
+}
+
+// SetBlobText indexes the text of the content for search. Content that is not
+// text is marked as indexed without any.
+func (gds GraphDatabaseService) SetBlobText(workspaceName string, sha256 string, text string) error {
+	setTextCypher := `
+		MATCH (b:Blob) WHERE b.workspace = $workspaceName AND b.sha256 = $sha256
+		SET b.text = CASE WHEN $text = "" THEN null ELSE $text END, b.textIndexed = true
+	`
+	setTextParams := map[string]any{
+		"workspaceName": workspaceName,
+		"sha256":        sha256,
+		"text":          text,
+	}
+	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		setTextCypher, setTextParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// IsBlobTextIndexed tells whether the content has already been looked at for
+// indexing, as it is shared by every file with the same content
+func (gds GraphDatabaseService) IsBlobTextIndexed(workspaceName string, sha256 string) (bool, error) {
+	checkTextCypher := `
+		MATCH (b:Blob) WHERE b.workspace = $workspaceName AND b.sha256 = $sha256
+		RETURN coalesce(b.textIndexed, false) AS indexed
+	`
+	checkTextParams := map[string]any{
+		"workspaceName": workspaceName,
+		"sha256":        sha256,
+	}
+	checkTextRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		checkTextCypher, checkTextParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return false, err
+	}
+	if len(checkTextRes.Records) == 0 {
+		return false, nil
+	}
+	indexed, _ := checkTextRes.Records[0].Get("indexed")
+	return indexed.(bool), nil
+}
+
+// createIndexes creates the indexes queries rely on when they are missing
+func (gds GraphDatabaseService) createIndexes() error {
+	createIndexCypher := `
+		CREATE FULLTEXT INDEX blobText IF NOT EXISTS FOR (b:Blob) ON EACH [b.text]
+	`
+	_, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		createIndexCypher, map[string]any{},
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	return err
+}
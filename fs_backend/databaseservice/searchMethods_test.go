@@ -0,0 +1,46 @@
+package databaseservice
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob  string
+		name  string
+		match bool
+	}{
+		{glob: "report.pdf", name: "report.pdf", match: true},
+		{glob: "report.pdf", name: "REPORT.PDF", match: true},
+		{glob: "report.pdf", name: "report-pdf", match: false},
+		{glob: "report", name: "report.pdf", match: false},
+		{glob: "*.pdf", name: "report.pdf", match: true},
+		{glob: "*.pdf", name: ".pdf", match: true},
+		{glob: "*.pdf", name: "report.pdf.txt", match: false},
+		{glob: "report?.pdf", name: "report1.pdf", match: true},
+		{glob: "report?.pdf", name: "report.pdf", match: false},
+		{glob: "report?.pdf", name: "report12.pdf", match: false},
+		// Regular expression characters in names are matched as they are
+		{glob: "a+b.txt", name: "a+b.txt", match: true},
+		{glob: "a+b.txt", name: "aab.txt", match: false},
+		{glob: "(draft) [v1]{2}.doc", name: "(draft) [v1]{2}.doc", match: true},
+		{glob: "(draft) [v1]{2}.doc", name: "draft v11.doc", match: false},
+		{glob: "^price$|cost", name: "^price$|cost", match: true},
+		{glob: "^price$|cost", name: "cost", match: false},
+		{glob: `back\slash`, name: `back\slash`, match: true},
+		{glob: `back\slash`, name: "back slash", match: false},
+	}
+	for _, test := range tests {
+		t.Run(test.glob+" "+test.name, func(t *testing.T) {
+			// Cypher matches regular expressions against the whole string
+			pattern, err := regexp.Compile("^(?:" + globToRegex(test.glob) + ")$")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match := pattern.MatchString(test.name); match != test.match {
+				t.Errorf("%q matches %q : %v, want %v", test.glob, test.name, match, test.match)
+			}
+		})
+	}
+}
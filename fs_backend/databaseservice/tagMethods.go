@@ -0,0 +1,87 @@
+package databaseservice
+
+import (
+	"log"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// AddItemLabels adds the tags the item does not have yet and sets the
+// metadata keys, keeping the other keys. It returns the updated item.
+func (gds GraphDatabaseService) AddItemLabels(location string, tags []string, metadata map[string]string) (any, error) {
+	metadataProps := map[string]any{}
+	for key, value := range metadata {
+		metadataProps[models.MetadataPropertyPrefix+key] = value
+	}
+	addLabelsCypher := `
+		MATCH (i:Directory|File) WHERE i.location = $location
+		SET i.tags = reduce(tags = coalesce(i.tags, []), tag IN $tags |
+			CASE WHEN tag IN tags THEN tags ELSE tags + tag END)
+		SET i += $metadata
+		RETURN i
+	`
+	addLabelsParams := map[string]any{
+		"location": location,
+		"tags":     tags,
+		"metadata": metadataProps,
+	}
+	return gds.queryItem(addLabelsCypher, addLabelsParams)
+}
+
+// RemoveItemLabels removes the tags and the metadata keys from the item and
+// returns the updated item
+func (gds GraphDatabaseService) RemoveItemLabels(location string, tags []string, keys []string) (any, error) {
+	// Setting a property to null removes it
+	metadataProps := map[string]any{}
+	for _, key := range keys {
+		metadataProps[models.MetadataPropertyPrefix+key] = nil
+	}
+	removeLabelsCypher := `
+		MATCH (i:Directory|File) WHERE i.location = $location
+		SET i.tags = [tag IN coalesce(i.tags, []) WHERE NOT tag IN $tags]
+		SET i += $metadata
+		RETURN i
+	`
+	removeLabelsParams := map[string]any{
+		"location": location,
+		"tags":     tags,
+		"metadata": metadataProps,
+	}
+	return gds.queryItem(removeLabelsCypher, removeLabelsParams)
+}
+
+// queryItem runs a query returning a single item as i
+func (gds GraphDatabaseService) queryItem(itemCypher string, itemParams map[string]any) (any, error) {
+	itemRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		itemCypher, itemParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	if len(itemRes.Records) == 0 {
+		return nil, apierrors.FileNotFound{}
+	}
+	r, _ := itemRes.Records[0].Get("i")
+	if listingKind(r.(neo4j.Node)) == 0 {
+		return models.GetDirectoryFromRecord(r), nil
+	}
+	return models.GetFileFromRecord(r), nil
+}
+
+// GetItem returns the file or directory at the location
+func (gds GraphDatabaseService) GetItem(location string) (any, error) {
+	getItemCypher := `
+		MATCH (i:Directory|File) WHERE i.location = $location
+		RETURN i
+	`
+	getItemParams := map[string]any{
+		"location": location,
+	}
+	return gds.queryItem(getItemCypher, getItemParams)
+}
@@ -0,0 +1,234 @@
+package databaseservice
+
+import (
+	"log"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Days deleted items stay in the trash unless the workspace sets otherwise
+const defaultTrashRetentionDays = 30
+
+// TrashItem moves the item with everything in it to the trash of the
+// workspace. The items keep their locations and role attachments, and are
+// relabeled so that they are no longer found as files and directories.
+func (gds GraphDatabaseService) TrashItem(entry models.TrashEntry) (models.TrashEntry, error) {
+	trashItemCypher := `
+		MATCH (parent:Directory)-[c:CONTAINS]->(top:Directory|File) WHERE top.location = $location
+		MATCH (w:Workspace) WHERE w.name = $workspaceName
+		CREATE (w)-[:HAS_TRASH]->(t:TrashEntry {
+			id: $id,
+			type: CASE WHEN top:File THEN "file" ELSE "directory" END,
+			name: top.name,
+			location: top.location,
+			workspaceName: $workspaceName,
+			deletedOn: $deletedOn,
+			deletedBy: $deletedBy
+		})-[:TRASHED]->(top)
+		DELETE c
+		WITH t, top
+		MATCH (top)-[:CONTAINS*0..]->(x)
+		FOREACH (f IN CASE WHEN x:File THEN [x] ELSE [] END | REMOVE f:File SET f:TrashedFile)
+		FOREACH (d IN CASE WHEN x:Directory THEN [x] ELSE [] END | REMOVE d:Directory SET d:TrashedDirectory)
+		WITH DISTINCT t
+		RETURN t
+	`
+	trashItemParams := map[string]any{
+		"id":            entry.Id,
+		"location":      entry.Location,
+		"workspaceName": entry.WorkspaceName,
+		"deletedOn":     entry.DeletedOn,
+		"deletedBy":     entry.DeletedBy,
+	}
+	trashItemRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		trashItemCypher, trashItemParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.TrashEntry{}, err
+	}
+	if len(trashItemRes.Records) == 0 {
+		return models.TrashEntry{}, apierrors.FileNotFound{}
+	}
+	entryRecord, _ := trashItemRes.Records[0].Get("t")
+	return models.GetTrashEntryFromRecord(entryRecord), nil
+}
+
+// GetTrashEntries returns the trash of the workspace, newest first. An
+// account id limits it to what that account deleted.
+func (gds GraphDatabaseService) GetTrashEntries(workspaceName string, deletedBy string) ([]models.TrashEntry, error) {
+	getEntriesCypher := `
+		MATCH (w:Workspace)-[:HAS_TRASH]->(t:TrashEntry)-[:TRASHED]->(top)
+		WHERE w.name = $workspaceName AND ($deletedBy = "" OR t.deletedBy = $deletedBy)
+		OPTIONAL MATCH (top)-[:CONTAINS*0..]->(f:TrashedFile)
+		RETURN t, count(f) AS files, coalesce(sum(f.size), 0) AS bytes
+		ORDER BY t.deletedOn DESC
+	`
+	getEntriesParams := map[string]any{
+		"workspaceName": workspaceName,
+		"deletedBy":     deletedBy,
+	}
+	getEntriesRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getEntriesCypher, getEntriesParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	entries := []models.TrashEntry{}
+	for _, record := range getEntriesRes.Records {
+		entryRecord, _ := record.Get("t")
+		files, _ := record.Get("files")
+		bytes, _ := record.Get("bytes")
+		entry := models.GetTrashEntryFromRecord(entryRecord)
+		entry.Files = files.(int64)
+		entry.Bytes = bytes.(int64)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (gds GraphDatabaseService) GetTrashEntry(workspaceName string, entryId string) (models.TrashEntry, error) {
+	getEntryCypher := `
+		MATCH (w:Workspace)-[:HAS_TRASH]->(t:TrashEntry)
+		WHERE w.name = $workspaceName AND t.id = $entryId
+		RETURN t
+	`
+	getEntryParams := map[string]any{
+		"workspaceName": workspaceName,
+		"entryId":       entryId,
+	}
+	getEntryRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getEntryCypher, getEntryParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.TrashEntry{}, err
+	}
+	if len(getEntryRes.Records) == 0 {
+		return models.TrashEntry{}, apierrors.TrashEntryNotFound{EntryId: entryId}
+	}
+	entryRecord, _ := getEntryRes.Records[0].Get("t")
+	return models.GetTrashEntryFromRecord(entryRecord), nil
+}
+
+// RestoreTrashEntry puts the item back at its location. It fails when the
+// parent directory is gone or its name has been taken meanwhile.
+func (gds GraphDatabaseService) RestoreTrashEntry(entry models.TrashEntry) error {
+	_, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		checkParentCypher := `
+			MATCH (p:Directory) WHERE p.location = $parentLocation
+			OPTIONAL MATCH (p)-[:CONTAINS]->(c) WHERE c.name = $name
+			RETURN count(c) AS count
+		`
+		checkParentParams := map[string]any{
+			"parentLocation": parentOf(entry.Location),
+			"name":           entry.Name,
+		}
+		checkParentRes, err := tx.Run(gds.ctx, checkParentCypher, checkParentParams)
+		if err != nil {
+			return nil, err
+		}
+		checkParentRecords, err := checkParentRes.Collect(gds.ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(checkParentRecords) == 0 {
+			return nil, apierrors.DirectoryNotFound{}
+		}
+		if count, _ := checkParentRecords[0].Get("count"); count.(int64) != 0 {
+			if entry.Type == "file" {
+				return nil, apierrors.FileWithSameNameAlreadyExists{ParentDirName: parentOf(entry.Location), FileName: entry.Name}
+			}
+			return nil, apierrors.DirectoryWithSameNameAlreadyExists{ParentDirName: parentOf(entry.Location), DirName: entry.Name}
+		}
+
+		restoreCypher := `
+			MATCH (t:TrashEntry)-[:TRASHED]->(top) WHERE t.id = $entryId
+			MATCH (p:Directory) WHERE p.location = $parentLocation
+			CREATE (p)-[:CONTAINS]->(top)
+			WITH t, top
+			MATCH (top)-[:CONTAINS*0..]->(x)
+			FOREACH (f IN CASE WHEN x:TrashedFile THEN [x] ELSE [] END | REMOVE f:TrashedFile SET f:File)
+			FOREACH (d IN CASE WHEN x:TrashedDirectory THEN [x] ELSE [] END | REMOVE d:TrashedDirectory SET d:Directory)
+			WITH DISTINCT t
+			DETACH DELETE t
+		`
+		restoreParams := map[string]any{
+			"entryId":        entry.Id,
+			"parentLocation": parentOf(entry.Location),
+		}
+		_, err = tx.Run(gds.ctx, restoreCypher, restoreParams)
+		return nil, err
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// PurgeTrashEntry deletes the item with everything in it for good and returns
+// the files whose content is not referenced any more
+func (gds GraphDatabaseService) PurgeTrashEntry(entry models.TrashEntry) ([]models.File, error) {
+	unreferencedFiles, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		getFilesCypher := `
+			MATCH (t:TrashEntry)-[:TRASHED]->(top) WHERE t.id = $entryId
+			MATCH (top)-[:CONTAINS*0..]->(f:TrashedFile)
+			RETURN f, [(f)-[:PREVIOUS_VERSION*]->(v:FileVersion) | v] AS versions
+		`
+		purgeCypher := `
+			MATCH (t:TrashEntry)-[:TRASHED]->(top) WHERE t.id = $entryId
+			OPTIONAL MATCH (top)-[:CONTAINS*0..]->(x)
+			OPTIONAL MATCH (x)-[:PREVIOUS_VERSION*]->(v:FileVersion)
+			DETACH DELETE v, x, t
+		`
+		purgeParams := map[string]any{
+			"entryId": entry.Id,
+		}
+		return gds.deleteAndReleaseBlobs(tx, entry.WorkspaceName, getFilesCypher, purgeCypher, purgeParams)
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	return unreferencedFiles.([]models.File), nil
+}
+
+// GetExpiredTrashEntries returns the entries of every workspace that have
+// been in the trash for longer than the workspace keeps them
+func (gds GraphDatabaseService) GetExpiredTrashEntries() ([]models.TrashEntry, error) {
+	getExpiredCypher := `
+		MATCH (w:Workspace)-[:HAS_TRASH]->(t:TrashEntry)
+		WITH t, coalesce(w.trashRetentionDays, $defaultRetentionDays) AS retentionDays
+		WHERE retentionDays > 0 AND t.deletedOn + duration({days: retentionDays}) < datetime()
+		RETURN t
+	`
+	getExpiredParams := map[string]any{
+		"defaultRetentionDays": defaultTrashRetentionDays,
+	}
+	getExpiredRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getExpiredCypher, getExpiredParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	entries := []models.TrashEntry{}
+	for _, record := range getExpiredRes.Records {
+		entryRecord, _ := record.Get("t")
+		entries = append(entries, models.GetTrashEntryFromRecord(entryRecord))
+	}
+	return entries, nil
+}
@@ -0,0 +1,223 @@
+package databaseservice
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// AddFileVersion gives the file the content of the upload and keeps the
+// content it had as its previous version
+func (gds GraphDatabaseService) AddFileVersion(file models.File, upload models.File, versionRetention int64) (models.File, []models.File, error) {
+	result, err := gds.executeWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+		return gds.addFileVersion(tx, file, upload, versionRetention)
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.File{}, nil, err
+	}
+	versioned := result.(fileVersionResult)
+	return versioned.file, versioned.unreferencedFiles, nil
+}
+
+type fileVersionResult struct {
+	file              models.File
+	unreferencedFiles []models.File
+}
+
+func (gds GraphDatabaseService) addFileVersion(tx neo4j.ManagedTransaction, file models.File, upload models.File, versionRetention int64) (fileVersionResult, error) {
+	// Locking the file first, so concurrent uploads of it queue up and each one
+	// keeps the content the previous one left
+	lockFileCypher := `
+		MATCH (f:File) WHERE f.id = $fileId
+		SET f.version = coalesce(f.version, 1)
+		RETURN f
+	`
+	lockFileRes, err := tx.Run(gds.ctx, lockFileCypher, map[string]any{"fileId": file.Id})
+	if err != nil {
+		return fileVersionResult{}, err
+	}
+	lockFileRecord, err := lockFileRes.Single(gds.ctx)
+	if err != nil {
+		return fileVersionResult{}, apierrors.FileNotFound{}
+	}
+	lockedNode, _ := lockFileRecord.Get("f")
+	file = models.GetFileFromRecord(lockedNode)
+
+	// Moving the current content to the head of the version chain
+	keepVersionCypher := `
+		MATCH (f:File) WHERE f.id = $fileId
+		OPTIONAL MATCH (f)-[s:STORED_AS]->(b:Blob)
+		OPTIONAL MATCH (f)-[p:PREVIOUS_VERSION]->(previous:FileVersion)
+		CREATE (f)-[:PREVIOUS_VERSION]->(v:FileVersion {
+			version: $version,
+			size: $size,
+			sha256: $sha256,
+			storedSize: $storedSize,
+			uploadedBy: $uploadedBy,
+			mimeType: $mimeType,
+			createdOn: $createdOn
+		})
+		FOREACH (blob IN CASE WHEN b IS NULL THEN [] ELSE [b] END | CREATE (v)-[:STORED_AS]->(blob))
+		FOREACH (older IN CASE WHEN previous IS NULL THEN [] ELSE [previous] END | CREATE (v)-[:PREVIOUS_VERSION]->(older))
+		DELETE s, p
+	`
+	keepVersionParams := map[string]any{
+		"fileId":     file.Id,
+		"version":    file.Version,
+		"size":       file.Size,
+		"sha256":     file.Sha256,
+		"storedSize": file.StoredSize,
+		"uploadedBy": file.UploadedBy,
+		"mimeType":   file.MimeType,
+		"createdOn":  file.ModifiedOn,
+	}
+	_, err = tx.Run(gds.ctx, keepVersionCypher, keepVersionParams)
+	if err != nil {
+		return fileVersionResult{}, err
+	}
+
+	setContentCypher := `
+		MATCH (f:File) WHERE f.id = $fileId
+		SET f.version = $version,
+			f.size = $size,
+			f.sha256 = $sha256,
+			f.storedSize = $storedSize,
+			f.uploadedBy = $uploadedBy,
+			f.mimeType = $mimeType,
+			f.modifiedOn = $modifiedOn
+		MERGE (b:Blob {workspace: $workspace, sha256: $sha256})
+		ON CREATE SET b.size = $size, b.storedSize = $storedSize, b.createdOn = $modifiedOn
+		CREATE (f)-[:STORED_AS]->(b)
+		RETURN f
+	`
+	setContentParams := map[string]any{
+		"fileId":     file.Id,
+		"version":    file.Version + 1,
+		"size":       upload.Size,
+		"sha256":     upload.Sha256,
+		"storedSize": upload.StoredSize,
+		"uploadedBy": upload.UploadedBy,
+		"mimeType":   upload.MimeType,
+		"modifiedOn": upload.CreatedOn,
+		"workspace":  strings.Split(file.Location, "/")[0],
+	}
+	setContentRes, err := tx.Run(gds.ctx, setContentCypher, setContentParams)
+	if err != nil {
+		return fileVersionResult{}, err
+	}
+	fileRecord, err := setContentRes.Single(gds.ctx)
+	if err != nil {
+		return fileVersionResult{}, err
+	}
+	fileNode, _ := fileRecord.Get("f")
+	versionedFile := models.GetFileFromRecord(fileNode)
+
+	unreferencedFiles, err := gds.pruneFileVersions(tx, versionedFile, versionRetention)
+	if err != nil {
+		return fileVersionResult{}, err
+	}
+	return fileVersionResult{file: versionedFile, unreferencedFiles: unreferencedFiles}, nil
+}
+
+// pruneFileVersions deletes the oldest versions of the file beyond the
+// retention and returns the files whose content is not referenced any more
+func (gds GraphDatabaseService) pruneFileVersions(tx neo4j.ManagedTransaction, file models.File, versionRetention int64) ([]models.File, error) {
+	if versionRetention == 0 {
+		return []models.File{}, nil
+	}
+	pruneVersionsCypher := `
+		MATCH (f:File)-[:PREVIOUS_VERSION*]->(v:FileVersion) WHERE f.id = $fileId
+		WITH v ORDER BY v.version DESC SKIP $versionRetention
+		WITH collect(v) AS pruned, collect(properties(v)) AS prunedProps
+		FOREACH (v IN pruned | DETACH DELETE v)
+		RETURN prunedProps
+	`
+	pruneVersionsParams := map[string]any{
+		"fileId":           file.Id,
+		"versionRetention": versionRetention,
+	}
+	pruneVersionsRes, err := tx.Run(gds.ctx, pruneVersionsCypher, pruneVersionsParams)
+	if err != nil {
+		return nil, err
+	}
+	pruneVersionsRecord, err := pruneVersionsRes.Single(gds.ctx)
+	if err != nil {
+		return nil, err
+	}
+	prunedRecord, _ := pruneVersionsRecord.Get("prunedProps")
+	prunedFiles := []models.File{}
+	for _, props := range prunedRecord.([]any) {
+		// The nodes are gone, so only their properties came back
+		version := models.GetFileVersionFromRecord(neo4j.Node{Props: props.(map[string]any)})
+		prunedFiles = append(prunedFiles, file.WithVersion(version))
+	}
+	return gds.releaseBlobs(tx, strings.Split(file.Location, "/")[0], prunedFiles)
+}
+
+// GetFileVersions returns the earlier versions of the file, newest first
+func (gds GraphDatabaseService) GetFileVersions(fileId string) ([]models.FileVersion, error) {
+	getVersionsCypher := `
+		MATCH (f:File)-[:PREVIOUS_VERSION*]->(v:FileVersion) WHERE f.id = $fileId
+		RETURN v ORDER BY v.version DESC
+	`
+	getVersionsParams := map[string]any{
+		"fileId": fileId,
+	}
+	getVersionsRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getVersionsCypher, getVersionsParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	versions := []models.FileVersion{}
+	for _, record := range getVersionsRes.Records {
+		versionRecord, _ := record.Get("v")
+		versions = append(versions, models.GetFileVersionFromRecord(versionRecord))
+	}
+	return versions, nil
+}
+
+func (gds GraphDatabaseService) GetFileVersion(fileId string, version int) (models.FileVersion, error) {
+	getVersionCypher := `
+		MATCH (f:File)-[:PREVIOUS_VERSION*]->(v:FileVersion)
+		WHERE f.id = $fileId AND v.version = $version
+		RETURN v
+	`
+	getVersionParams := map[string]any{
+		"fileId":  fileId,
+		"version": version,
+	}
+	getVersionRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getVersionCypher, getVersionParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.FileVersion{}, err
+	}
+	if len(getVersionRes.Records) == 0 {
+		return models.FileVersion{}, apierrors.FileNotFound{}
+	}
+	versionRecord, _ := getVersionRes.Records[0].Get("v")
+	return models.GetFileVersionFromRecord(versionRecord), nil
+}
+
+// RestoreFileVersion makes the content of the version the newest version of
+// the file. History is kept, so the content being replaced becomes a version
+// too.
+func (gds GraphDatabaseService) RestoreFileVersion(file models.File, version models.FileVersion, restoredBy string, versionRetention int64) (models.File, []models.File, error) {
+	restored := file.WithVersion(version)
+	restored.UploadedBy = restoredBy
+	restored.CreatedOn = time.Now().UTC()
+	return gds.AddFileVersion(file, restored, versionRetention)
+}
@@ -322,3 +322,365 @@ func (gds GraphDatabaseService) GetWorkspacesForOwner(ownerAccountId string) ([]
 
 	return workspaces, nil
 }
+
+func (gds GraphDatabaseService) GetDataKeys(workspaceName string) ([]models.DataKey, error) {
+	getDataKeysCypher := `
+		MATCH (w:Workspace)-[:HAS_DATA_KEY]->(k:DataKey) WHERE w.name = $workspaceName
+		RETURN k
+		ORDER BY k.version
+	`
+	getDataKeysParams := map[string]any{
+		"workspaceName": workspaceName,
+	}
+	getDataKeysRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getDataKeysCypher, getDataKeysParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	dataKeys := []models.DataKey{}
+	for _, record := range getDataKeysRes.Records {
+		dataKeyRecord, _ := record.Get("k")
+		dataKeys = append(dataKeys, models.GetDataKeyFromRecord(dataKeyRecord))
+	}
+	return dataKeys, nil
+}
+
+// AddDataKey stores the key as the next version of the workspace. It reports
+// false when another version was added meanwhile, so versions never clash.
+func (gds GraphDatabaseService) AddDataKey(workspaceName string, dataKey models.DataKey) (bool, error) {
+	addDataKeyCypher := `
+		MATCH (w:Workspace) WHERE w.name = $workspaceName
+		OPTIONAL MATCH (w)-[:HAS_DATA_KEY]->(existing:DataKey)
+		WITH w, coalesce(max(existing.version), 0) AS latestVersion
+		WHERE latestVersion = $version - 1
+		CREATE (w)-[:HAS_DATA_KEY]->(k:DataKey {
+			version: $version,
+			wrappedKey: $wrappedKey,
+			createdOn: $createdOn
+		})
+		RETURN count(k) AS created
+	`
+	addDataKeyParams := map[string]any{
+		"workspaceName": workspaceName,
+		"version":       dataKey.Version,
+		"wrappedKey":    dataKey.WrappedKey,
+		"createdOn":     dataKey.CreatedOn,
+	}
+	addDataKeyRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		addDataKeyCypher, addDataKeyParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return false, err
+	}
+	if len(addDataKeyRes.Records) == 0 {
+		return false, nil
+	}
+	created, _ := addDataKeyRes.Records[0].Get("created")
+	return created.(int64) == 1, nil
+}
+
+func (gds GraphDatabaseService) GetWorkspaceSettings(workspaceName string) (models.WorkspaceSettings, error) {
+	getSettingsCypher := `
+		MATCH (w:Workspace) WHERE w.name = $workspaceName
+		RETURN coalesce(w.compression, true) AS compression,
+			coalesce(w.quotaBytes, 0) AS quotaBytes,
+			coalesce(w.quotaFiles, 0) AS quotaFiles,
+			coalesce(w.versionRetention, 0) AS versionRetention,
+			coalesce(w.trashRetentionDays, $defaultTrashRetentionDays) AS trashRetentionDays,
+			coalesce(w.allowedMimeTypes, []) AS allowedMimeTypes,
+			coalesce(w.deniedMimeTypes, []) AS deniedMimeTypes
+	`
+	getSettingsParams := map[string]any{
+		"workspaceName":             workspaceName,
+		"defaultTrashRetentionDays": defaultTrashRetentionDays,
+	}
+	getSettingsRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getSettingsCypher, getSettingsParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.WorkspaceSettings{}, err
+	}
+	if len(getSettingsRes.Records) == 0 {
+		return models.WorkspaceSettings{}, apierrors.WorkspaceNotFound{}
+	}
+	compression, _ := getSettingsRes.Records[0].Get("compression")
+	quotaBytes, _ := getSettingsRes.Records[0].Get("quotaBytes")
+	quotaFiles, _ := getSettingsRes.Records[0].Get("quotaFiles")
+	versionRetention, _ := getSettingsRes.Records[0].Get("versionRetention")
+	trashRetentionDays, _ := getSettingsRes.Records[0].Get("trashRetentionDays")
+	allowedMimeTypes, _ := getSettingsRes.Records[0].Get("allowedMimeTypes")
+	deniedMimeTypes, _ := getSettingsRes.Records[0].Get("deniedMimeTypes")
+	settings := models.WorkspaceSettings{
+		Compression:        compression.(bool),
+		QuotaBytes:         quotaBytes.(int64),
+		QuotaFiles:         quotaFiles.(int64),
+		VersionRetention:   versionRetention.(int64),
+		TrashRetentionDays: trashRetentionDays.(int64),
+		AllowedMimeTypes:   []string{},
+		DeniedMimeTypes:    []string{},
+	}
+	for _, mimeType := range allowedMimeTypes.([]any) {
+		settings.AllowedMimeTypes = append(settings.AllowedMimeTypes, mimeType.(string))
+	}
+	for _, mimeType := range deniedMimeTypes.([]any) {
+		settings.DeniedMimeTypes = append(settings.DeniedMimeTypes, mimeType.(string))
+	}
+	return settings, nil
+}
+
+func (gds GraphDatabaseService) UpdateWorkspaceSettings(workspaceName string, settings models.WorkspaceSettings) error {
+	updateSettingsCypher := `
+		MATCH (w:Workspace) WHERE w.name = $workspaceName
+		SET w.compression = $compression, w.quotaBytes = $quotaBytes, w.quotaFiles = $quotaFiles,
+			w.versionRetention = $versionRetention, w.trashRetentionDays = $trashRetentionDays,
+			w.allowedMimeTypes = $allowedMimeTypes, w.deniedMimeTypes = $deniedMimeTypes
+		RETURN count(w) AS count
+	`
+	updateSettingsParams := map[string]any{
+		"workspaceName":      workspaceName,
+		"compression":        settings.Compression,
+		"quotaBytes":         settings.QuotaBytes,
+		"quotaFiles":         settings.QuotaFiles,
+		"versionRetention":   settings.VersionRetention,
+		"trashRetentionDays": settings.TrashRetentionDays,
+		"allowedMimeTypes":   settings.AllowedMimeTypes,
+		"deniedMimeTypes":    settings.DeniedMimeTypes,
+	}
+	updateSettingsRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		updateSettingsCypher, updateSettingsParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	count, _ := updateSettingsRes.Records[0].Get("count")
+	if count.(int64) == 0 {
+		return apierrors.WorkspaceNotFound{}
+	}
+	return nil
+}
+
+// Query of the usage of a workspace, read on its own and while reserving quota
+const workspaceUsageCypher = `
+	MATCH (root:RootDirectory) WHERE root.location = $workspaceName
+	OPTIONAL MATCH (root)-[:CONTAINS*]->(live:File)
+	WITH root, collect(live) AS liveFiles
+	OPTIONAL MATCH (w:Workspace)-[:HAS_TRASH]->(:TrashEntry)-[:TRASHED]->(top)-[:CONTAINS*0..]->(trashed:TrashedFile)
+	WHERE w.name = $workspaceName
+	WITH liveFiles + collect(trashed) AS allFiles
+	UNWIND CASE WHEN allFiles = [] THEN [null] ELSE allFiles END AS f
+	WITH f, [(f)-[:PREVIOUS_VERSION*]->(v:FileVersion) WHERE $accountId = "" OR v.uploadedBy = $accountId | v.size] AS versionSizes
+	WITH f, versionSizes, ($accountId = "" OR f.uploadedBy = $accountId) AS isUploader
+	RETURN count(CASE WHEN isUploader THEN f END) AS files,
+		coalesce(sum(CASE WHEN isUploader THEN f.size ELSE 0 END + reduce(total = 0, size IN versionSizes | total + size)), 0) AS bytes
+`
+
+// GetWorkspaceUsage counts the files of the workspace and the size of them
+// and their versions. Files in the trash count until they are purged. An
+// account id limits it to what that account uploaded.
+func (gds GraphDatabaseService) GetWorkspaceUsage(workspaceName string, accountId string) (models.WorkspaceUsage, error) {
+	getUsageParams := map[string]any{
+		"workspaceName": workspaceName,
+		"accountId":     accountId,
+	}
+	getUsageRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		workspaceUsageCypher, getUsageParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.WorkspaceUsage{}, err
+	}
+	if len(getUsageRes.Records) == 0 {
+		return models.WorkspaceUsage{}, apierrors.WorkspaceNotFound{}
+	}
+	files, _ := getUsageRes.Records[0].Get("files")
+	bytes, _ := getUsageRes.Records[0].Get("bytes")
+	return models.WorkspaceUsage{
+		Files: files.(int64),
+		Bytes: bytes.(int64),
+	}, nil
+}
+
+// GetWorkspacesUsage returns the usage of each of the workspaces along with
+// their quotas, by the name of the workspace
+func (gds GraphDatabaseService) GetWorkspacesUsage(workspaceNames []string) (map[string]models.WorkspaceUsage, error) {
+	getUsageCypher := `
+		UNWIND $workspaceNames AS workspaceName
+		CALL {
+			WITH workspaceName
+			MATCH (w:Workspace) WHERE w.name = workspaceName
+			MATCH (root:RootDirectory) WHERE root.location = workspaceName
+			OPTIONAL MATCH (root)-[:CONTAINS*]->(live:File)
+			WITH w, collect(live) AS liveFiles
+			OPTIONAL MATCH (w)-[:HAS_TRASH]->(:TrashEntry)-[:TRASHED]->(top)-[:CONTAINS*0..]->(trashed:TrashedFile)
+			WITH w, liveFiles + collect(trashed) AS allFiles
+			UNWIND CASE WHEN allFiles = [] THEN [null] ELSE allFiles END AS f
+			WITH w, f, [(f)-[:PREVIOUS_VERSION*]->(v:FileVersion) | v.size] AS versionSizes
+			RETURN count(f) AS files,
+				coalesce(sum(f.size + reduce(total = 0, size IN versionSizes | total + size)), 0) AS bytes,
+				coalesce(w.quotaBytes, 0) AS quotaBytes,
+				coalesce(w.quotaFiles, 0) AS quotaFiles
+		}
+		RETURN workspaceName, files, bytes, quotaBytes, quotaFiles
+	`
+	getUsageParams := map[string]any{
+		"workspaceNames": workspaceNames,
+	}
+	getUsageRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getUsageCypher, getUsageParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	usages := map[string]models.WorkspaceUsage{}
+	for _, record := range getUsageRes.Records {
+		workspaceName, _ := record.Get("workspaceName")
+		files, _ := record.Get("files")
+		bytes, _ := record.Get("bytes")
+		quotaBytes, _ := record.Get("quotaBytes")
+		quotaFiles, _ := record.Get("quotaFiles")
+		usages[workspaceName.(string)] = models.WorkspaceUsage{
+			Files:      files.(int64),
+			Bytes:      bytes.(int64),
+			QuotaBytes: quotaBytes.(int64),
+			QuotaFiles: quotaFiles.(int64),
+		}
+	}
+	return usages, nil
+}
+
+func (gds GraphDatabaseService) SetServiceAccountQuota(workspaceName string, username string, quotaBytes int64) error {
+	setQuotaCypher := `
+		MATCH (sa:ServiceAccount)-[:SERVICES]->(w:Workspace)
+		WHERE sa.username = $username AND w.name = $workspaceName
+		SET sa.quotaBytes = $quotaBytes
+		RETURN count(sa) AS count
+	`
+	setQuotaParams := map[string]any{
+		"username":      username,
+		"workspaceName": workspaceName,
+		"quotaBytes":    quotaBytes,
+	}
+	setQuotaRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		setQuotaCypher, setQuotaParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	count, _ := setQuotaRes.Records[0].Get("count")
+	if count.(int64) == 0 {
+		return apierrors.AccountNotFound{}
+	}
+	return nil
+}
+
+// GetDirectorySize adds up the files and directories below the directory
+func (gds GraphDatabaseService) GetDirectorySize(location string) (models.DirectorySize, error) {
+	getSizeCypher := `
+		MATCH (top:Directory) WHERE top.location = $location
+		OPTIONAL MATCH (top)-[:CONTAINS*]->(i)
+		RETURN count(CASE WHEN i:File THEN 1 END) AS files,
+			count(CASE WHEN i:Directory THEN 1 END) AS directories,
+			coalesce(sum(i.size), 0) AS bytes
+	`
+	getSizeParams := map[string]any{
+		"location": location,
+	}
+	getSizeRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getSizeCypher, getSizeParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.DirectorySize{}, err
+	}
+	if len(getSizeRes.Records) == 0 {
+		return models.DirectorySize{}, apierrors.DirectoryNotFound{}
+	}
+	files, _ := getSizeRes.Records[0].Get("files")
+	directories, _ := getSizeRes.Records[0].Get("directories")
+	bytes, _ := getSizeRes.Records[0].Get("bytes")
+	return models.DirectorySize{
+		Bytes:       bytes.(int64),
+		Files:       files.(int64),
+		Directories: directories.(int64),
+	}, nil
+}
+
+// GetUsageShares breaks the current files of the workspace down by top level
+// item, by extension and by uploading account. Files at the top level count
+// as their own share.
+func (gds GraphDatabaseService) GetUsageShares(workspaceName string) (models.UsageReport, error) {
+	getSharesCypher := `
+		MATCH (root:RootDirectory) WHERE root.location = $workspaceName
+		CALL {
+			WITH root
+			MATCH (root)-[:CONTAINS]->(top)-[:CONTAINS*0..]->(f:File)
+			WITH top, count(f) AS files, sum(f.size) AS bytes
+			ORDER BY bytes DESC
+			RETURN collect({key: top.location, name: top.name, files: files, bytes: bytes}) AS byDirectory
+		}
+		CALL {
+			WITH root
+			MATCH (root)-[:CONTAINS*]->(f:File)
+			WITH CASE WHEN f.name CONTAINS "." THEN toLower(last(split(f.name, "."))) ELSE "" END AS type, f
+			WITH type, count(f) AS files, sum(f.size) AS bytes
+			ORDER BY bytes DESC
+			RETURN collect({key: type, name: type, files: files, bytes: bytes}) AS byType
+		}
+		CALL {
+			WITH root
+			MATCH (root)-[:CONTAINS*]->(f:File)
+			WITH coalesce(f.uploadedBy, "") AS uploader, count(f) AS files, sum(f.size) AS bytes
+			OPTIONAL MATCH (a:OwnerAccount|ServiceAccount) WHERE a.id = uploader
+			WITH uploader, files, bytes, coalesce(a.username, a.name, "") AS name
+			ORDER BY bytes DESC
+			RETURN collect({key: uploader, name: name, files: files, bytes: bytes}) AS byUploader
+		}
+		RETURN byDirectory, byType, byUploader
+	`
+	getSharesParams := map[string]any{
+		"workspaceName": workspaceName,
+	}
+	getSharesRes, err := neo4j.ExecuteQuery(gds.ctx, gds.driver,
+		getSharesCypher, getSharesParams,
+		neo4j.EagerResultTransformer,
+		neo4j.ExecuteQueryWithDatabase("neo4j"),
+	)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.UsageReport{}, err
+	}
+	if len(getSharesRes.Records) == 0 {
+		return models.UsageReport{}, apierrors.WorkspaceNotFound{}
+	}
+	byDirectory, _ := getSharesRes.Records[0].Get("byDirectory")
+	byType, _ := getSharesRes.Records[0].Get("byType")
+	byUploader, _ := getSharesRes.Records[0].Get("byUploader")
+	return models.UsageReport{
+		ByDirectory: models.GetUsageSharesFromRecord(byDirectory),
+		ByType:      models.GetUsageSharesFromRecord(byType),
+		ByUploader:  models.GetUsageSharesFromRecord(byUploader),
+	}, nil
+}
@@ -0,0 +1,123 @@
+package fileservice
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// BlobStore is where the content of files is kept. Blobs are addressed by
+// keys of the form "workspaceName/fileId".
+type BlobStore interface {
+	// Put stores the reader under the key, replacing any existing blob
+	Put(key string, reader io.Reader, size int64) error
+	// Get reads the blob from the offset. A negative length reads to the end.
+	Get(key string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (BlobInfo, error)
+	// List returns every blob whose key starts with the prefix
+	List(prefix string) ([]BlobInfo, error)
+}
+
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// openedBlob is a blob whose size and layout have been read, so any number
+// of ranges can be read from it without reading them again
+type openedBlob interface {
+	info() BlobInfo
+	// get reads the blob from the offset. A negative length reads to the end.
+	get(offset int64, length int64) (io.ReadCloser, error)
+}
+
+// blobOpener is implemented by stores that read something of a blob before
+// reading its content
+type blobOpener interface {
+	open(key string) (openedBlob, error)
+}
+
+// openBlob opens the blob for reading ranges. Stores with nothing to read up
+// front only have the blob looked up.
+func openBlob(store BlobStore, key string) (openedBlob, error) {
+	if opener, isOpener := store.(blobOpener); isOpener {
+		return opener.open(key)
+	}
+	info, err := store.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return &storedBlob{store: store, key: key, stat: info}, nil
+}
+
+type storedBlob struct {
+	store BlobStore
+	key   string
+	stat  BlobInfo
+}
+
+func (sb *storedBlob) info() BlobInfo {
+	return sb.stat
+}
+
+func (sb *storedBlob) get(offset int64, length int64) (io.ReadCloser, error) {
+	return sb.store.Get(sb.key, offset, length)
+}
+
+// blobReader lets a blob be read and seeked like a local file. A ranged read
+// is only opened from the store when reading after a seek. The blob is opened
+// once, so seeking does not read the layout of the blob again.
+type blobReader struct {
+	blob   openedBlob
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	if br.offset >= br.size {
+		return 0, io.EOF
+	}
+	if br.body == nil {
+		body, err := br.blob.get(br.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		br.body = body
+	}
+	n, err := br.body.Read(p)
+	br.offset += int64(n)
+	return n, err
+}
+
+func (br *blobReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = br.offset + offset
+	case io.SeekEnd:
+		newOffset = br.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if newOffset != br.offset && br.body != nil {
+		br.body.Close()
+		br.body = nil
+	}
+	br.offset = newOffset
+	return newOffset, nil
+}
+
+func (br *blobReader) Close() error {
+	if br.body == nil {
+		return nil
+	}
+	return br.body.Close()
+}
@@ -0,0 +1,287 @@
+package fileservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed blobs are a header of the magic and the frame size, independent
+// zstd frames of the content and a seek table of the compressed frame sizes.
+// The table is followed by the frame count, the content size and the magic,
+// so a range is read by decoding only the frames covering it.
+const (
+	compressionFrameSize  = 1024 * 1024
+	compressionHeaderSize = 12
+	compressionFooterSize = 20
+)
+
+var compressionMagic = []byte("FSZST\x00\x00\x01")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type compressionIndex struct {
+	frameSize    int64
+	contentSize  int64
+	frameOffsets []int64
+}
+
+// compressedBlobStore decompresses blobs written with PutCompressed. Put
+// stores blobs as they are, so only content worth compressing is compressed.
+type compressedBlobStore struct {
+	inner BlobStore
+}
+
+// compressToFile writes the content of the reader to the file in the
+// compressed blob format and returns the compressed size
+func compressToFile(file *os.File, reader io.Reader, size int64) (int64, error) {
+	header := make([]byte, compressionHeaderSize)
+	copy(header, compressionMagic)
+	binary.BigEndian.PutUint32(header[8:], compressionFrameSize)
+	_, err := file.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	frame := make([]byte, compressionFrameSize)
+	compressedFrame := []byte{}
+	frameSizes := []byte{}
+	remaining := size
+	for remaining > 0 {
+		length := min(remaining, compressionFrameSize)
+		_, err := io.ReadFull(reader, frame[:length])
+		if err != nil {
+			return 0, err
+		}
+		remaining -= length
+		compressedFrame = zstdEncoder.EncodeAll(frame[:length], compressedFrame[:0])
+		_, err = file.Write(compressedFrame)
+		if err != nil {
+			return 0, err
+		}
+		frameSizes = binary.BigEndian.AppendUint32(frameSizes, uint32(len(compressedFrame)))
+	}
+
+	footer := binary.BigEndian.AppendUint32(frameSizes, uint32(len(frameSizes)/4))
+	footer = binary.BigEndian.AppendUint64(footer, uint64(size))
+	footer = append(footer, compressionMagic...)
+	_, err = file.Write(footer)
+	if err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekCurrent)
+}
+
+// compressionRatio returns the compressed size of the sample relative to its size
+func compressionRatio(sample []byte) float64 {
+	if len(sample) == 0 {
+		return 1
+	}
+	return float64(len(zstdEncoder.EncodeAll(sample, nil))) / float64(len(sample))
+}
+
+func readRange(blob openedBlob, offset int64, length int64) ([]byte, error) {
+	reader, err := blob.get(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+// readCompressionIndex returns nil when the blob is not compressed
+func readCompressionIndex(blob openedBlob, key string) (*compressionIndex, error) {
+	storedSize := blob.info().Size
+	if storedSize < compressionHeaderSize+compressionFooterSize {
+		return nil, nil
+	}
+	header, err := readRange(blob, 0, compressionHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:8], compressionMagic) {
+		return nil, nil
+	}
+	footer, err := readRange(blob, storedSize-compressionFooterSize, compressionFooterSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(footer[12:], compressionMagic) {
+		return nil, errors.New("compressed blob " + key + " has no seek table")
+	}
+
+	frameCount := int64(binary.BigEndian.Uint32(footer[0:4]))
+	index := &compressionIndex{
+		frameSize:    int64(binary.BigEndian.Uint32(header[8:12])),
+		contentSize:  int64(binary.BigEndian.Uint64(footer[4:12])),
+		frameOffsets: make([]int64, frameCount+1),
+	}
+	seekTable, err := readRange(blob, storedSize-compressionFooterSize-4*frameCount, 4*frameCount)
+	if err != nil {
+		return nil, err
+	}
+	index.frameOffsets[0] = compressionHeaderSize
+	for frame := int64(0); frame < frameCount; frame++ {
+		index.frameOffsets[frame+1] = index.frameOffsets[frame] + int64(binary.BigEndian.Uint32(seekTable[4*frame:]))
+	}
+	return index, nil
+}
+
+func (cs *compressedBlobStore) Put(key string, reader io.Reader, size int64) error {
+	return cs.inner.Put(key, reader, size)
+}
+
+// PutCompressed compresses the content in a temporary file before storing it,
+// as the store needs to know the size up front
+func (cs *compressedBlobStore) PutCompressed(key string, reader io.Reader, size int64, tempLocation string) error {
+	tempFile, err := os.CreateTemp(tempLocation, "compress-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	compressedSize, err := compressToFile(tempFile, reader, size)
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return cs.inner.Put(key, tempFile, compressedSize)
+}
+
+// compressedBlob is a compressed blob whose seek table has been read
+type compressedBlob struct {
+	inner openedBlob
+	stat  BlobInfo
+	index *compressionIndex
+}
+
+// open reads the seek table of the blob. Blobs stored uncompressed are opened
+// as they are.
+func (cs *compressedBlobStore) open(key string) (openedBlob, error) {
+	inner, err := openBlob(cs.inner, key)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readCompressionIndex(inner, key)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return inner, nil
+	}
+
+	stat := inner.info()
+	stat.Size = index.contentSize
+	return &compressedBlob{inner: inner, stat: stat, index: index}, nil
+}
+
+func (cb *compressedBlob) info() BlobInfo {
+	return cb.stat
+}
+
+func (cb *compressedBlob) get(offset int64, length int64) (io.ReadCloser, error) {
+	index := cb.index
+	if length < 0 || offset+length > index.contentSize {
+		length = index.contentSize - offset
+	}
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	// Reading only the frames holding the range
+	firstFrame := offset / index.frameSize
+	lastFrame := (offset + length - 1) / index.frameSize
+	body, err := cb.inner.get(index.frameOffsets[firstFrame], index.frameOffsets[lastFrame+1]-index.frameOffsets[firstFrame])
+	if err != nil {
+		return nil, err
+	}
+	return &decompressingReader{
+		body:      body,
+		index:     index,
+		frame:     firstFrame,
+		skip:      offset - firstFrame*index.frameSize,
+		remaining: length,
+	}, nil
+}
+
+func (cs *compressedBlobStore) Get(key string, offset int64, length int64) (io.ReadCloser, error) {
+	blob, err := cs.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return blob.get(offset, length)
+}
+
+func (cs *compressedBlobStore) Delete(key string) error {
+	return cs.inner.Delete(key)
+}
+
+// Stat reports the size of the decompressed content
+func (cs *compressedBlobStore) Stat(key string) (BlobInfo, error) {
+	blob, err := cs.open(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return blob.info(), nil
+}
+
+// List reports the stored sizes, as the seek tables are not read for listing
+func (cs *compressedBlobStore) List(prefix string) ([]BlobInfo, error) {
+	return cs.inner.List(prefix)
+}
+
+type decompressingReader struct {
+	body      io.ReadCloser
+	index     *compressionIndex
+	frame     int64
+	skip      int64
+	remaining int64
+	decoded   []byte
+	buffer    []byte
+}
+
+func (dr *decompressingReader) Read(p []byte) (int, error) {
+	if dr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(dr.decoded) == 0 {
+		compressedFrame := make([]byte, dr.index.frameOffsets[dr.frame+1]-dr.index.frameOffsets[dr.frame])
+		_, err := io.ReadFull(dr.body, compressedFrame)
+		if err != nil {
+			return 0, err
+		}
+		dr.buffer, err = zstdDecoder.DecodeAll(compressedFrame, dr.buffer[:0])
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(dr.buffer)) <= dr.skip {
+			return 0, io.ErrUnexpectedEOF
+		}
+		dr.decoded = dr.buffer[dr.skip:]
+		dr.skip = 0
+		dr.frame++
+	}
+	n := copy(p, dr.decoded[:min(int64(len(dr.decoded)), dr.remaining)])
+	dr.decoded = dr.decoded[n:]
+	dr.remaining -= int64(n)
+	return n, nil
+}
+
+func (dr *decompressingReader) Close() error {
+	return dr.body.Close()
+}
@@ -0,0 +1,258 @@
+package fileservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// countingBlobStore counts the requests reaching the store, as each one is a
+// round trip with object storage
+type countingBlobStore struct {
+	BlobStore
+	gets  int
+	stats int
+}
+
+func (cs *countingBlobStore) Get(key string, offset int64, length int64) (io.ReadCloser, error) {
+	cs.gets++
+	return cs.BlobStore.Get(key, offset, length)
+}
+
+func (cs *countingBlobStore) Stat(key string) (BlobInfo, error) {
+	cs.stats++
+	return cs.BlobStore.Stat(key)
+}
+
+// compressibleContent mixes text with random bytes, so frames compress to
+// different sizes
+func compressibleContent(t *testing.T, size int) []byte {
+	t.Helper()
+	content := bytes.Buffer{}
+	for line := 0; content.Len() < size; line++ {
+		fmt.Fprintf(&content, "line %d of the content\n", line)
+		if line%1000 == 0 {
+			content.Write(randomContent(t, 4096))
+		}
+	}
+	return content.Bytes()[:size]
+}
+
+func putCompressed(t *testing.T, store *compressedBlobStore, key string, content []byte) {
+	t.Helper()
+	err := store.PutCompressed(key, bytes.NewReader(content), int64(len(content)), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressToFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		frames int
+	}{
+		{name: "empty", size: 0, frames: 0},
+		{name: "one byte", size: 1, frames: 1},
+		{name: "one whole frame", size: compressionFrameSize, frames: 1},
+		{name: "one byte into the second frame", size: compressionFrameSize + 1, frames: 2},
+		{name: "partial last frame", size: 2*compressionFrameSize + 1000, frames: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := compressibleContent(t, test.size)
+			file, err := os.CreateTemp(t.TempDir(), "compressed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			compressedSize, err := compressToFile(file, bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := os.ReadFile(file.Name())
+			if err != nil || int64(len(compressed)) != compressedSize {
+				t.Fatalf("reported %d bytes, wrote %d", compressedSize, len(compressed))
+			}
+
+			// The footer ends the blob with the frame count, the content size and the magic
+			footer := compressed[len(compressed)-compressionFooterSize:]
+			if !bytes.Equal(footer[12:], compressionMagic) || !bytes.Equal(compressed[:8], compressionMagic) {
+				t.Fatal("blob does not start and end with the magic")
+			}
+			if frames := int(binary.BigEndian.Uint32(footer[0:4])); frames != test.frames {
+				t.Errorf("got %d frames, want %d", frames, test.frames)
+			}
+			if size := int(binary.BigEndian.Uint64(footer[4:12])); size != test.size {
+				t.Errorf("got content size %d, want %d", size, test.size)
+			}
+			if frameSize := binary.BigEndian.Uint32(compressed[8:12]); frameSize != compressionFrameSize {
+				t.Errorf("got frame size %d, want %d", frameSize, compressionFrameSize)
+			}
+
+			// The seek table adds up to the frames between the header and itself
+			framesSize := 0
+			seekTable := compressed[len(compressed)-compressionFooterSize-4*test.frames : len(compressed)-compressionFooterSize]
+			for frame := 0; frame < test.frames; frame++ {
+				framesSize += int(binary.BigEndian.Uint32(seekTable[4*frame:]))
+			}
+			if compressionHeaderSize+framesSize+len(seekTable)+compressionFooterSize != len(compressed) {
+				t.Errorf("seek table covers %d bytes of frames in a blob of %d", framesSize, len(compressed))
+			}
+		})
+	}
+}
+
+func TestCompressedBlobStoreRanges(t *testing.T) {
+	const frame = compressionFrameSize
+	disk, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := compressibleContent(t, 3*frame+frame/3)
+	size := int64(len(content))
+
+	// Compressed blobs are read the same with and without encryption below
+	stores := map[string]*compressedBlobStore{
+		"plain":     {inner: disk},
+		"encrypted": {inner: &encryptedBlobStore{inner: disk, keyRing: NewKeyRing(newTestMasterKey(t), newMemoryDataKeyStore()), encryptWrites: true}},
+	}
+	for name, store := range stores {
+		putCompressed(t, store, "ws/"+name, content)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int64
+	}{
+		{name: "whole blob", offset: 0, length: -1},
+		{name: "inside the first frame", offset: 10, length: 1000},
+		{name: "whole first frame", offset: 0, length: frame},
+		{name: "across one frame boundary", offset: frame - 100, length: 200},
+		{name: "across two frame boundaries", offset: frame - 1, length: frame + 2},
+		{name: "from a frame boundary", offset: 2 * frame, length: 50},
+		{name: "up to a frame boundary", offset: frame, length: frame},
+		{name: "into the short last frame", offset: 3*frame - 10, length: 20},
+		{name: "last byte", offset: size - 1, length: 1},
+		{name: "to the end", offset: frame + 5, length: -1},
+		{name: "past the end", offset: size - 5, length: 100},
+		{name: "at the end", offset: size, length: 1},
+	}
+	for name, store := range stores {
+		info, err := store.Stat("ws/" + name)
+		if err != nil || info.Size != size {
+			t.Fatalf("%s : got size %d and error %v, want %d", name, info.Size, err, size)
+		}
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				end := size
+				if test.length >= 0 {
+					end = min(test.offset+test.length, size)
+				}
+				got, err := readBlob(store, "ws/"+name, test.offset, test.length)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, content[test.offset:end]) {
+					t.Errorf("got %d bytes not matching the %d bytes of the range", len(got), end-test.offset)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressedBlobStoreUncompressedBlobs(t *testing.T) {
+	disk, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &compressedBlobStore{inner: disk}
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "shorter than a header and footer", content: []byte("short")},
+		{name: "no magic", content: bytes.Repeat([]byte("stored as it is "), 100)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := store.Put("ws/blob", bytes.NewReader(test.content), int64(len(test.content))); err != nil {
+				t.Fatal(err)
+			}
+			info, err := store.Stat("ws/blob")
+			if err != nil || info.Size != int64(len(test.content)) {
+				t.Fatalf("got size %d and error %v, want %d", info.Size, err, len(test.content))
+			}
+			got, err := readBlob(store, "ws/blob", 2, 3)
+			if err != nil || !bytes.Equal(got, test.content[2:5]) {
+				t.Errorf("got %q and error %v, want %q", got, err, test.content[2:5])
+			}
+		})
+	}
+}
+
+func TestCompressedBlobStoreCorruptSeekTable(t *testing.T) {
+	disk, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &compressedBlobStore{inner: disk}
+	putCompressed(t, store, "ws/blob", compressibleContent(t, compressionFrameSize+10))
+
+	// Cutting the footer off leaves a compressed header without a seek table
+	compressed, err := readBlob(disk, "ws/blob", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := compressed[:len(compressed)-4]
+	if err := disk.Put("ws/cut", bytes.NewReader(cut), int64(len(cut))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat("ws/cut"); err == nil {
+		t.Error("blob without a seek table opened without error")
+	}
+}
+
+// A reader opens the blob once, so every range read after a seek is a single
+// request to the store however many layers the blob passes through
+func TestBlobReaderReadsLayoutOnce(t *testing.T) {
+	const frame = compressionFrameSize
+	disk, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingBlobStore{BlobStore: disk}
+	encrypted := &encryptedBlobStore{inner: counting, keyRing: NewKeyRing(newTestMasterKey(t), newMemoryDataKeyStore()), encryptWrites: true}
+	store := &compressedBlobStore{inner: encrypted}
+	content := compressibleContent(t, 2*frame+10)
+	putCompressed(t, store, "ws/blob", content)
+
+	blob, err := openBlob(store, "ws/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &blobReader{blob: blob, size: blob.info().Size}
+	defer reader.Close()
+	counting.gets, counting.stats = 0, 0
+
+	buffer := make([]byte, 100)
+	for _, offset := range []int64{10, frame - 50, 2 * frame, 5} {
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(reader, buffer[:10]); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buffer[:10], content[offset:offset+10]) {
+			t.Errorf("read at %d does not match the content", offset)
+		}
+	}
+	if counting.gets != 4 || counting.stats != 0 {
+		t.Errorf("4 reads made %d gets and %d stats, want 4 gets and no stats", counting.gets, counting.stats)
+	}
+}
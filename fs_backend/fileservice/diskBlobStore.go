@@ -0,0 +1,140 @@
+package fileservice
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fs_backend/apierrors"
+)
+
+// DiskBlobStore keeps blobs as files under the root directory
+type DiskBlobStore struct {
+	root string
+}
+
+func NewDiskBlobStore(root string) (*DiskBlobStore, error) {
+	err := os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskBlobStore{root: root}, nil
+}
+
+func (ds *DiskBlobStore) blobLocation(key string) string {
+	return filepath.Join(ds.root, filepath.FromSlash(key))
+}
+
+func (ds *DiskBlobStore) Put(key string, reader io.Reader, size int64) error {
+	location := ds.blobLocation(key)
+	err := os.MkdirAll(filepath.Dir(location), os.ModePerm)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+
+	// Writing to a temporary file first so readers never see a partial blob
+	tempFile, err := os.CreateTemp(filepath.Dir(location), filepath.Base(location)+".*.tmp")
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	written, err := io.Copy(tempFile, reader)
+	closeErr := tempFile.Close()
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	if closeErr != nil {
+		log.Default().Println(closeErr.Error())
+		return closeErr
+	}
+	if written != size {
+		return io.ErrUnexpectedEOF
+	}
+	return os.Rename(tempFile.Name(), location)
+}
+
+func (ds *DiskBlobStore) Get(key string, offset int64, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(ds.blobLocation(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, apierrors.BlobNotFound{Key: key}
+		}
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (ds *DiskBlobStore) Delete(key string) error {
+	err := os.Remove(ds.blobLocation(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return apierrors.BlobNotFound{Key: key}
+		}
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (ds *DiskBlobStore) Stat(key string) (BlobInfo, error) {
+	info, err := os.Stat(ds.blobLocation(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return BlobInfo{}, apierrors.BlobNotFound{Key: key}
+		}
+		log.Default().Println(err.Error())
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (ds *DiskBlobStore) List(prefix string) ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+	err := filepath.WalkDir(ds.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
+		}
+		relativePath, err := filepath.Rel(ds.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	return blobs, nil
+}
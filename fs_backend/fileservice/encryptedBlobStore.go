@@ -0,0 +1,324 @@
+package fileservice
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Encrypted blobs start with a header of the magic, the data key version and
+// a random nonce prefix. The content follows in AES-GCM sealed segments, so
+// a range can be read by only opening the segments it covers.
+const (
+	encryptionSegmentSize = 64 * 1024
+	encryptionHeaderSize  = 20
+	aesGCMTagSize         = 16
+)
+
+var encryptionMagic = []byte("FSENC\x00\x00\x01")
+
+type encryptionHeader struct {
+	version     int
+	noncePrefix []byte
+}
+
+// encryptedBlobStore encrypts blobs with the data key of their workspace
+// before they reach the underlying store. Blobs written before encryption was
+// enabled have no header and are passed through as they are.
+type encryptedBlobStore struct {
+	inner         BlobStore
+	keyRing       *KeyRing
+	encryptWrites bool
+}
+
+func workspaceOfKey(key string) string {
+	workspaceName, _, _ := strings.Cut(key, "/")
+	return workspaceName
+}
+
+func segmentCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encryptionSegmentSize - 1) / encryptionSegmentSize
+}
+
+func encryptedSize(size int64) int64 {
+	return encryptionHeaderSize + size + segmentCount(size)*aesGCMTagSize
+}
+
+func decryptedSize(storedSize int64) int64 {
+	contentSize := storedSize - encryptionHeaderSize
+	segments := (contentSize + encryptionSegmentSize + aesGCMTagSize - 1) / (encryptionSegmentSize + aesGCMTagSize)
+	return contentSize - segments*aesGCMTagSize
+}
+
+// segmentNonce is unique for every segment of every blob, as the prefix is
+// random per write
+func segmentNonce(noncePrefix []byte, index int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[8:], uint32(index))
+	return nonce
+}
+
+// segmentData binds a segment to its blob, its position and whether it ends
+// the blob, so segments cannot be reordered, swapped or cut off
+func segmentData(key string, index int64, isLast bool) []byte {
+	data := make([]byte, len(key)+9)
+	copy(data, key)
+	binary.BigEndian.PutUint64(data[len(key):], uint64(index))
+	if isLast {
+		data[len(data)-1] = 1
+	}
+	return data
+}
+
+func newSegmentCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readEncryptionHeader returns nil when the blob is not encrypted
+func readEncryptionHeader(blob openedBlob) (*encryptionHeader, error) {
+	reader, err := blob.get(0, encryptionHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	header := make([]byte, encryptionHeaderSize)
+	_, err = io.ReadFull(reader, header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], encryptionMagic) {
+		return nil, nil
+	}
+	return &encryptionHeader{
+		version:     int(binary.BigEndian.Uint32(header[8:12])),
+		noncePrefix: header[12:20],
+	}, nil
+}
+
+func (es *encryptedBlobStore) Put(key string, reader io.Reader, size int64) error {
+	if !es.encryptWrites {
+		return es.inner.Put(key, reader, size)
+	}
+
+	version, dataKey, err := es.keyRing.ActiveDataKey(workspaceOfKey(key))
+	if err != nil {
+		return err
+	}
+	segmentCipher, err := newSegmentCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	binary.BigEndian.PutUint32(header[8:12], uint32(version))
+	if _, err := rand.Read(header[12:20]); err != nil {
+		return err
+	}
+	noncePrefix := header[12:20]
+
+	// Sealing segment by segment while the store reads, so nothing is buffered whole
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeReader.Close()
+	go func() {
+		segment := make([]byte, encryptionSegmentSize)
+		sealed := make([]byte, 0, encryptionSegmentSize+aesGCMTagSize)
+		remaining := size
+		for index := int64(0); index < segmentCount(size); index++ {
+			length := min(remaining, encryptionSegmentSize)
+			_, err := io.ReadFull(reader, segment[:length])
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			remaining -= length
+			sealed = segmentCipher.Seal(sealed[:0], segmentNonce(noncePrefix, index), segment[:length], segmentData(key, index, remaining == 0))
+			_, err = pipeWriter.Write(sealed)
+			if err != nil {
+				return
+			}
+		}
+		pipeWriter.Close()
+	}()
+
+	return es.inner.Put(key, io.MultiReader(bytes.NewReader(header), pipeReader), encryptedSize(size))
+}
+
+// encryptedBlob is an encrypted blob whose header has been read and whose
+// data key is ready
+type encryptedBlob struct {
+	inner       openedBlob
+	key         string
+	stat        BlobInfo
+	cipher      cipher.AEAD
+	noncePrefix []byte
+}
+
+// open reads the header of the blob and gets its data key. Blobs stored
+// unencrypted are opened as they are.
+func (es *encryptedBlobStore) open(key string) (openedBlob, error) {
+	inner, err := openBlob(es.inner, key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readEncryptionHeader(inner)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return inner, nil
+	}
+	dataKey, err := es.keyRing.DataKey(workspaceOfKey(key), header.version)
+	if err != nil {
+		return nil, err
+	}
+	segmentCipher, err := newSegmentCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := inner.info()
+	stat.Size = decryptedSize(stat.Size)
+	return &encryptedBlob{
+		inner:       inner,
+		key:         key,
+		stat:        stat,
+		cipher:      segmentCipher,
+		noncePrefix: header.noncePrefix,
+	}, nil
+}
+
+func (eb *encryptedBlob) info() BlobInfo {
+	return eb.stat
+}
+
+func (eb *encryptedBlob) get(offset int64, length int64) (io.ReadCloser, error) {
+	size := eb.stat.Size
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	// Reading only the segments holding the range
+	firstSegment := offset / encryptionSegmentSize
+	lastSegment := (offset + length - 1) / encryptionSegmentSize
+	sealedSegmentSize := int64(encryptionSegmentSize + aesGCMTagSize)
+	body, err := eb.inner.get(encryptionHeaderSize+firstSegment*sealedSegmentSize, (lastSegment-firstSegment+1)*sealedSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		key:           eb.key,
+		body:          body,
+		cipher:        eb.cipher,
+		noncePrefix:   eb.noncePrefix,
+		size:          size,
+		segment:       firstSegment,
+		skip:          offset - firstSegment*encryptionSegmentSize,
+		remaining:     length,
+		sealedSegment: make([]byte, sealedSegmentSize),
+	}, nil
+}
+
+func (es *encryptedBlobStore) Get(key string, offset int64, length int64) (io.ReadCloser, error) {
+	blob, err := es.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return blob.get(offset, length)
+}
+
+func (es *encryptedBlobStore) Delete(key string) error {
+	return es.inner.Delete(key)
+}
+
+// Stat reports the size of the decrypted content and fails when the data key
+// is not available, so callers learn before streaming that it cannot be read
+func (es *encryptedBlobStore) Stat(key string) (BlobInfo, error) {
+	blob, err := es.open(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return blob.info(), nil
+}
+
+// List reports the stored sizes, as the headers are not read for listing
+func (es *encryptedBlobStore) List(prefix string) ([]BlobInfo, error) {
+	return es.inner.List(prefix)
+}
+
+// KeyVersion returns the data key version the blob is encrypted with, or 0
+// when it is stored unencrypted
+func (es *encryptedBlobStore) KeyVersion(key string) (int, error) {
+	blob, err := openBlob(es.inner, key)
+	if err != nil {
+		return 0, err
+	}
+	header, err := readEncryptionHeader(blob)
+	if err != nil || header == nil {
+		return 0, err
+	}
+	return header.version, nil
+}
+
+type decryptingReader struct {
+	key           string
+	body          io.ReadCloser
+	cipher        cipher.AEAD
+	noncePrefix   []byte
+	size          int64
+	segment       int64
+	skip          int64
+	remaining     int64
+	sealedSegment []byte
+	opened        []byte
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	if dr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(dr.opened) == 0 {
+		// The last segment of the blob is shorter than the others
+		segmentLength := min(encryptionSegmentSize, dr.size-dr.segment*encryptionSegmentSize)
+		sealedSegment := dr.sealedSegment[:segmentLength+aesGCMTagSize]
+		_, err := io.ReadFull(dr.body, sealedSegment)
+		if err != nil {
+			return 0, err
+		}
+		isLast := (dr.segment+1)*encryptionSegmentSize >= dr.size
+		opened, err := dr.cipher.Open(sealedSegment[:0], segmentNonce(dr.noncePrefix, dr.segment), sealedSegment, segmentData(dr.key, dr.segment, isLast))
+		if err != nil {
+			return 0, err
+		}
+		dr.opened = opened[dr.skip:]
+		dr.skip = 0
+		dr.segment++
+	}
+	n := copy(p, dr.opened[:min(int64(len(dr.opened)), dr.remaining)])
+	dr.opened = dr.opened[n:]
+	dr.remaining -= int64(n)
+	return n, nil
+}
+
+func (dr *decryptingReader) Close() error {
+	return dr.body.Close()
+}
@@ -0,0 +1,264 @@
+package fileservice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"fs_backend/apierrors"
+)
+
+func newTestEncryptedStore(t *testing.T) *encryptedBlobStore {
+	t.Helper()
+	inner, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &encryptedBlobStore{
+		inner:         inner,
+		keyRing:       NewKeyRing(newTestMasterKey(t), newMemoryDataKeyStore()),
+		encryptWrites: true,
+	}
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	t.Helper()
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func readBlob(store BlobStore, key string, offset int64, length int64) ([]byte, error) {
+	reader, err := store.Get(key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestEncryptedSize(t *testing.T) {
+	const segment = encryptionSegmentSize
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		// Empty content still has one sealed segment
+		{size: 0, want: encryptionHeaderSize + aesGCMTagSize},
+		{size: 1, want: encryptionHeaderSize + 1 + aesGCMTagSize},
+		{size: segment - 1, want: encryptionHeaderSize + segment - 1 + aesGCMTagSize},
+		{size: segment, want: encryptionHeaderSize + segment + aesGCMTagSize},
+		{size: segment + 1, want: encryptionHeaderSize + segment + 1 + 2*aesGCMTagSize},
+		{size: 2 * segment, want: encryptionHeaderSize + 2*segment + 2*aesGCMTagSize},
+		{size: 3*segment + 5, want: encryptionHeaderSize + 3*segment + 5 + 4*aesGCMTagSize},
+	}
+	for _, test := range tests {
+		if got := encryptedSize(test.size); got != test.want {
+			t.Errorf("encryptedSize(%d) = %d, want %d", test.size, got, test.want)
+		}
+		if got := decryptedSize(test.want); got != test.size {
+			t.Errorf("decryptedSize(%d) = %d, want %d", test.want, got, test.size)
+		}
+	}
+}
+
+func TestEncryptedBlobStoreRanges(t *testing.T) {
+	const segment = encryptionSegmentSize
+	store := newTestEncryptedStore(t)
+	content := randomContent(t, 2*segment+segment/2)
+	size := int64(len(content))
+	if err := store.Put("ws/blob", bytes.NewReader(content), size); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.inner.Stat("ws/blob")
+	if err != nil || stored.Size != encryptedSize(size) {
+		t.Fatalf("stored %d bytes, want %d", stored.Size, encryptedSize(size))
+	}
+	info, err := store.Stat("ws/blob")
+	if err != nil || info.Size != size {
+		t.Fatalf("got size %d and error %v, want %d", info.Size, err, size)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int64
+	}{
+		{name: "whole blob", offset: 0, length: -1},
+		{name: "start of the first segment", offset: 0, length: 100},
+		{name: "whole first segment", offset: 0, length: segment},
+		{name: "inside a segment", offset: 1000, length: 5000},
+		{name: "across one boundary", offset: segment - 10, length: 20},
+		{name: "across two boundaries", offset: segment - 1, length: segment + 2},
+		{name: "from a boundary", offset: segment, length: 100},
+		{name: "up to a boundary", offset: segment - 100, length: 100},
+		{name: "into the short last segment", offset: 2*segment - 5, length: 10},
+		{name: "last byte", offset: size - 1, length: 1},
+		{name: "to the end", offset: segment + 7, length: -1},
+		{name: "past the end", offset: size - 10, length: 100},
+		{name: "at the end", offset: size, length: 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end := size
+			if test.length >= 0 {
+				end = min(test.offset+test.length, size)
+			}
+			got, err := readBlob(store, "ws/blob", test.offset, test.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content[test.offset:end]) {
+				t.Errorf("got %d bytes not matching the %d bytes of the range", len(got), end-test.offset)
+			}
+		})
+	}
+}
+
+func TestEncryptedBlobStoreSizes(t *testing.T) {
+	const segment = encryptionSegmentSize
+	store := newTestEncryptedStore(t)
+	for _, size := range []int{0, 1, segment - 1, segment, segment + 1, 2 * segment} {
+		content := randomContent(t, size)
+		if err := store.Put("ws/blob", bytes.NewReader(content), int64(size)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readBlob(store, "ws/blob", 0, -1)
+		if err != nil {
+			t.Fatalf("reading %d bytes : %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%d bytes did not read back", size)
+		}
+	}
+}
+
+func TestEncryptedBlobStoreRotatedKey(t *testing.T) {
+	store := newTestEncryptedStore(t)
+	oldContent := randomContent(t, encryptionSegmentSize+10)
+	if err := store.Put("ws/old", bytes.NewReader(oldContent), int64(len(oldContent))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.keyRing.Rotate("ws"); err != nil {
+		t.Fatal(err)
+	}
+	newContent := randomContent(t, 100)
+	if err := store.Put("ws/new", bytes.NewReader(newContent), int64(len(newContent))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		content []byte
+		version int
+	}{
+		{key: "ws/old", content: oldContent, version: 1},
+		{key: "ws/new", content: newContent, version: 2},
+	}
+	for _, test := range tests {
+		version, err := store.KeyVersion(test.key)
+		if err != nil || version != test.version {
+			t.Errorf("%s is encrypted with version %d, want %d", test.key, version, test.version)
+		}
+		// Ranges across segments of blobs under the rotated key still open
+		got, err := readBlob(store, test.key, 5, int64(len(test.content)-10))
+		if err != nil {
+			t.Fatalf("reading %s : %v", test.key, err)
+		}
+		if !bytes.Equal(got, test.content[5:len(test.content)-5]) {
+			t.Errorf("%s did not read back", test.key)
+		}
+	}
+
+	// Reencrypting moves the old blob to the active key
+	info, _ := store.Stat("ws/old")
+	reader, _ := store.Get("ws/old", 0, -1)
+	err := store.Put("ws/old", reader, info.Size)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := store.KeyVersion("ws/old"); version != 2 {
+		t.Errorf("reencrypted blob has version %d, want 2", version)
+	}
+	if got, err := readBlob(store, "ws/old", 0, -1); err != nil || !bytes.Equal(got, oldContent) {
+		t.Errorf("reencrypted blob did not read back : %v", err)
+	}
+}
+
+func TestEncryptedBlobStoreTampering(t *testing.T) {
+	store := newTestEncryptedStore(t)
+	content := randomContent(t, 3*encryptionSegmentSize)
+	if err := store.Put("ws/blob", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := readBlob(store.inner, "ws/blob", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedSegmentSize := encryptionSegmentSize + aesGCMTagSize
+
+	flipped := append([]byte{}, sealed...)
+	flipped[encryptionHeaderSize+sealedSegmentSize+1] ^= 1
+	reordered := append([]byte{}, sealed[:encryptionHeaderSize]...)
+	reordered = append(reordered, sealed[encryptionHeaderSize+sealedSegmentSize:encryptionHeaderSize+2*sealedSegmentSize]...)
+	reordered = append(reordered, sealed[encryptionHeaderSize:encryptionHeaderSize+sealedSegmentSize]...)
+	reordered = append(reordered, sealed[encryptionHeaderSize+2*sealedSegmentSize:]...)
+	cutOff := sealed[:encryptionHeaderSize+2*sealedSegmentSize]
+
+	tests := []struct {
+		name   string
+		key    string
+		sealed []byte
+	}{
+		{name: "flipped bit", key: "ws/flipped", sealed: flipped},
+		{name: "reordered segments", key: "ws/reordered", sealed: reordered},
+		{name: "cut off last segment", key: "ws/cut", sealed: cutOff},
+		{name: "moved to another key", key: "ws/moved", sealed: sealed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := store.inner.Put(test.key, bytes.NewReader(test.sealed), int64(len(test.sealed))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readBlob(store, test.key, 0, -1); err == nil {
+				t.Error("tampered blob read without error")
+			}
+		})
+	}
+}
+
+func TestEncryptedBlobStorePlainBlobs(t *testing.T) {
+	store := newTestEncryptedStore(t)
+	content := []byte("stored before encryption was enabled")
+	if err := store.inner.Put("ws/plain", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readBlob(store, "ws/plain", 7, 6)
+	if err != nil || !bytes.Equal(got, content[7:13]) {
+		t.Errorf("got %q and error %v, want %q", got, err, content[7:13])
+	}
+	if version, err := store.KeyVersion("ws/plain"); err != nil || version != 0 {
+		t.Errorf("got version %d and error %v for a plain blob, want 0", version, err)
+	}
+}
+
+func TestEncryptedBlobStoreWithoutMasterKey(t *testing.T) {
+	store := newTestEncryptedStore(t)
+	content := randomContent(t, 10)
+	if err := store.Put("ws/blob", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	// A server started without the master key cannot read the blob
+	withoutKey := &encryptedBlobStore{inner: store.inner, keyRing: NewKeyRing(nil, newMemoryDataKeyStore())}
+	if _, err := withoutKey.Stat("ws/blob"); !errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+		t.Errorf("got error %v, want the key to be unavailable", err)
+	}
+}
@@ -1,76 +1,175 @@
 package fileservice
 
 import (
-	"bufio"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
 	"errors"
-	"fs_backend/models"
+	"hash"
 	"io"
 	"log"
+	"mime"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
 )
 
+// FileService keeps the content of files in the blob store. Uploads are
+// staged on local disk while chunks arrive and are stored once complete.
+// Blobs pass through compression and then encryption on their way to the
+// backend, which is the configured storage driver.
 type FileService struct {
-	internalLocation  string
+	store              BlobStore
+	compressedStore    *compressedBlobStore
+	encryptedStore     *encryptedBlobStore
+	backend            BlobStore
+	keyRing            *KeyRing
+	compressionEnabled bool
+	textIndexLimit     int64
+	stagingLocation    string
+	references         BlobReferenceStore
+	// Commits hold the lock for reading so a blob being reused is never
+	// deleted between the store and the record
+	blobLock          *sync.RWMutex
+	reaper            *reaper
 	DownloadChunkSize int
+	UploadChunkSize   int
 }
 
-func (fs *FileService) Initialize() {
-	location := os.Getenv("STORAGE_LOCATION")
-	if location == "" {
-		location = "_storage/"
+func (fs *FileService) Initialize(dataKeyStore DataKeyStore, references BlobReferenceStore) {
+	var err error
+	var store BlobStore
+	driver := os.Getenv("STORAGE_DRIVER")
+	switch driver {
+	case "", "disk":
+		location := os.Getenv("STORAGE_LOCATION")
+		if location == "" {
+			location = "_storage/"
+		}
+		store, err = NewDiskBlobStore(location)
+		if err != nil {
+			log.Fatalln("Error creating storage at", location, ":", err.Error())
+		}
+	case "s3":
+		config := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+		}
+		if config.Endpoint == "" || config.Bucket == "" {
+			log.Fatalln("Needed an S3 endpoint and bucket")
+		}
+		log.Default().Println("Connecting to S3 storage at", config.Endpoint)
+		store, err = NewS3BlobStore(config)
+		if err != nil {
+			log.Fatalln("Error connecting S3 storage : ", err.Error())
+		}
+	default:
+		log.Fatalln("Unknown storage driver", driver)
+	}
+
+	// Encrypted blobs are always decrypted when the master key is there. Without
+	// it they fail to read instead of being served as ciphertext.
+	masterKey, err := loadMasterKey()
+	if err != nil {
+		log.Fatalln("Error reading master key : ", err.Error())
+	}
+	encryptionEnabled := os.Getenv("ENCRYPTION_ENABLED") == "true"
+	if encryptionEnabled && masterKey == nil {
+		log.Fatalln("Needed a master key as encryption is enabled")
+	}
+	fs.keyRing = NewKeyRing(masterKey, dataKeyStore)
+	fs.encryptedStore = &encryptedBlobStore{
+		inner:         store,
+		keyRing:       fs.keyRing,
+		encryptWrites: encryptionEnabled,
 	}
-	fs.internalLocation = location
+	fs.backend = store
+	fs.references = references
+	fs.blobLock = &sync.RWMutex{}
+	fs.compressionEnabled = os.Getenv("COMPRESSION_ENABLED") == "true"
+	fs.textIndexLimit = readTextIndexLimit(encryptionEnabled)
+	fs.compressedStore = &compressedBlobStore{inner: fs.encryptedStore}
+	fs.store = fs.compressedStore
 
-	// Creating temp directory
-	if _, err := os.Stat(fs.internalLocation); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(fs.internalLocation, os.ModePerm)
+	// Creating the staging directory for uploads in progress
+	fs.stagingLocation = os.Getenv("UPLOAD_STAGING_LOCATION")
+	if fs.stagingLocation == "" {
+		fs.stagingLocation = "_staging/"
+	}
+	if _, err := os.Stat(fs.stagingLocation); errors.Is(err, os.ErrNotExist) {
+		err := os.MkdirAll(fs.stagingLocation, os.ModePerm)
 		if err != nil {
 			log.Default().Println(err.Error())
 		}
 	}
 
-	// Chunk size for download
+	// Chunk size for download and upload
 	fs.DownloadChunkSize = 1024 * 1024
+	fs.UploadChunkSize = 1024 * 1024
 }
 
-func (fs FileService) GetInternalLocation() string {
-	return fs.internalLocation
-}
-
+// Cleanup stops the background work. Stored blobs and staged uploads are
+// kept, so uploads in progress resume after a restart. Staged files whose
+// session is gone are left to garbage collection.
 func (fs FileService) Cleanup() {
-	os.RemoveAll(fs.internalLocation)
+	fs.StopReaper()
 }
 
+// CreateWorkspaceSpace prepares the storage of a new workspace. Blobs are
+// namespaced by the key prefix, so the store needs nothing up front.
 func (fs FileService) CreateWorkspaceSpace(workspaceName string) error {
-	err := os.Mkdir(fs.internalLocation+"/"+workspaceName, os.ModePerm)
-	return err
+	return nil
 }
 
 func (fs FileService) DeleteWorkspaceSpace(workspaceName string) error {
-	err := os.RemoveAll(fs.internalLocation + "/" + workspaceName)
-	return err
+	blobs, err := fs.store.List(workspaceName + "/")
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		err = fs.store.Delete(blob.Key)
+		if err != nil && !errors.As(err, &apierrors.BlobNotFound{}) {
+			return err
+		}
+	}
+	return nil
 }
 
-func (fs FileService) getFileLocation(fileProperties models.File) string {
-	locationSplit := strings.Split(fileProperties.Location, "/")
-	workspaceName := locationSplit[0]
-	fileInternalLocation := fs.internalLocation + "/" + workspaceName + "/" + fileProperties.Id
-	return fileInternalLocation
+// blobKey addresses the content of the file by its checksum, so identical files
+// of a workspace share one blob. Files stored before checksums were recorded
+// keep a blob of their own named by the file id.
+func (fs FileService) blobKey(fileProperties models.File) string {
+	workspaceName, _, _ := strings.Cut(fileProperties.Location, "/")
+	if fileProperties.Sha256 != "" {
+		return workspaceName + "/" + fileProperties.Sha256
+	}
+	return workspaceName + "/" + fileProperties.Id
 }
 
-func (fs FileService) ReadChunkFromFile(fileProperties models.File, chunkNumber int) ([]byte, error) {
-	file, err := os.Open(fs.getFileLocation(fileProperties))
+func (fs FileService) stagedFileLocation(fileProperties models.File) string {
+	return filepath.Join(fs.stagingLocation, fileProperties.Id)
+}
+
+// OpenFile opens the stored file for streaming and seeking
+func (fs FileService) OpenFile(fileProperties models.File) (io.ReadSeekCloser, error) {
+	blob, err := openBlob(fs.store, fs.blobKey(fileProperties))
 	if err != nil {
-		return []byte{}, err
+		return nil, err
 	}
+	return &blobReader{blob: blob, size: blob.info().Size}, nil
+}
 
+func (fs FileService) ReadChunkFromFile(fileProperties models.File, chunkNumber int) ([]byte, error) {
 	start := fs.DownloadChunkSize * (chunkNumber - 1)
 
-	if chunkNumber != 1 {
-		file.Seek(int64(start), io.SeekStart)
-	}
-
 	var chunk []byte
 	if start+fs.DownloadChunkSize > fileProperties.Size {
 		chunk = make([]byte, fileProperties.Size-start)
@@ -78,7 +177,13 @@ func (fs FileService) ReadChunkFromFile(fileProperties models.File, chunkNumber
 		chunk = make([]byte, fs.DownloadChunkSize)
 	}
 
-	_, err = bufio.NewReader(file).Read(chunk)
+	blob, err := fs.store.Get(fs.blobKey(fileProperties), int64(start), int64(len(chunk)))
+	if err != nil {
+		return []byte{}, err
+	}
+	defer blob.Close()
+
+	_, err = io.ReadFull(blob, chunk)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -86,34 +191,323 @@ func (fs FileService) ReadChunkFromFile(fileProperties models.File, chunkNumber
 	return chunk, nil
 }
 
-func (fs FileService) WriteChunkToFile(fileProperties models.File, chunk []byte) error {
-	file, err := os.OpenFile(fs.getFileLocation(fileProperties), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+// UploadChunkTotal returns the number of upload chunks for a file of the size
+func (fs FileService) UploadChunkTotal(fileSize int) int {
+	return (fileSize + fs.UploadChunkSize - 1) / fs.UploadChunkSize
+}
+
+// UploadChunkLength returns the expected length of the upload chunk. Every
+// chunk is of the upload chunk size except the last one.
+func (fs FileService) UploadChunkLength(fileSize int, chunkNumber int) int {
+	start := fs.UploadChunkSize * (chunkNumber - 1)
+	if start+fs.UploadChunkSize > fileSize {
+		return fileSize - start
+	}
+	return fs.UploadChunkSize
+}
+
+// WriteChunkToFile streams the chunk to its own offset, so chunks can arrive
+// in any order and a retried chunk overwrites the same bytes.
+func (fs FileService) WriteChunkToFile(fileProperties models.File, chunkNumber int, chunk io.Reader) (int64, error) {
+	return fs.WriteStreamAt(fileProperties, int64(fs.UploadChunkSize)*int64(chunkNumber-1), chunk)
+}
+
+// WriteStreamAt streams the reader into the staged upload starting at the
+// offset and returns the number of bytes written.
+func (fs FileService) WriteStreamAt(fileProperties models.File, offset int64, reader io.Reader) (int64, error) {
+	file, err := os.OpenFile(fs.stagedFileLocation(fileProperties), os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return 0, err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(io.NewOffsetWriter(file, offset), reader)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return written, err
+	}
+	return written, nil
+}
+
+// TruncateFile discards everything in the staged upload after the size
+func (fs FileService) TruncateFile(fileProperties models.File, size int64) error {
+	err := os.Truncate(fs.stagedFileLocation(fileProperties), size)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// StagedFileSize returns the number of bytes staged for the upload
+func (fs FileService) StagedFileSize(fileProperties models.File) (int64, error) {
+	info, err := os.Stat(fs.stagedFileLocation(fileProperties))
+	if err != nil {
+		log.Default().Println(err.Error())
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ResumeContentHash returns the SHA-256 hash used for file checksums, carrying
+// on from the saved state when there is one.
+func (fs FileService) ResumeContentHash(state []byte) (hash.Hash, error) {
+	contentHash := sha256.New()
+	if len(state) == 0 {
+		return contentHash, nil
+	}
+	err := contentHash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	return contentHash, nil
+}
+
+// SaveContentHash returns the state of the hash so it can be resumed later
+func (fs FileService) SaveContentHash(contentHash hash.Hash) ([]byte, error) {
+	return contentHash.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// HashStagedRange feeds the staged bytes of the upload from the offset into the hash
+func (fs FileService) HashStagedRange(fileProperties models.File, contentHash hash.Hash, offset int64, length int64) error {
+	file, err := os.Open(fs.stagedFileLocation(fileProperties))
 	if err != nil {
 		log.Default().Println(err.Error())
 		return err
 	}
 	defer file.Close()
 
-	_, err = file.Write(chunk)
+	copied, err := io.Copy(contentHash, io.NewSectionReader(file, offset, length))
 	if err != nil {
 		log.Default().Println(err.Error())
 		return err
 	}
+	if copied != length {
+		return io.ErrUnexpectedEOF
+	}
 	return nil
 }
 
-func (fs FileService) DeleteFileFromInternalLocation(fileProperties models.File) error {
-	if _, err := os.Stat(fs.internalLocation); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(fs.internalLocation, os.ModePerm)
+// StoreStagedFile puts the completed upload into the blob store unless a blob
+// with the same content is already there, and reports whether it wrote one.
+// Content worth it is compressed when the workspace allows compression. The
+// staged file is kept until DeleteStagedFile so a failed commit can be
+// retried. The caller must hold the blobs from HoldBlobs until the record of
+// the file is created, so an existing blob is not deleted meanwhile.
+func (fs FileService) StoreStagedFile(fileProperties models.File, compressionAllowed bool) (bool, error) {
+	_, err := fs.backend.Stat(fs.blobKey(fileProperties))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.As(err, &apierrors.BlobNotFound{}) {
+		return false, err
+	}
+
+	file, err := os.Open(fs.stagedFileLocation(fileProperties))
+	if err != nil {
+		log.Default().Println(err.Error())
+		return false, err
+	}
+	defer file.Close()
+
+	if fs.compressionEnabled && compressionAllowed && fs.worthCompressing(fileProperties, file) {
+		err = fs.compressedStore.PutCompressed(fs.blobKey(fileProperties), file, int64(fileProperties.Size), fs.stagingLocation)
+	} else {
+		err = fs.store.Put(fs.blobKey(fileProperties), file, int64(fileProperties.Size))
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// worthCompressing decides by the type of the file, and for unknown types by
+// how well the start of the staged file compresses
+func (fs FileService) worthCompressing(fileProperties models.File, file *os.File) bool {
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(fileProperties.Name)))
+	if strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] {
+		return true
+	}
+	if strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") || incompressibleTypes[mediaType] {
+		return false
+	}
+
+	sample := make([]byte, 64*1024)
+	n, err := file.ReadAt(sample, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	return compressionRatio(sample[:n]) < 0.9
+}
+
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-ndjson":   true,
+	"application/sql":        true,
+	"image/svg+xml":          true,
+}
+
+var incompressibleTypes = map[string]bool{
+	"application/zip":             true,
+	"application/gzip":            true,
+	"application/x-gzip":          true,
+	"application/x-bzip2":         true,
+	"application/x-xz":            true,
+	"application/x-7z-compressed": true,
+	"application/vnd.rar":         true,
+	"application/zstd":            true,
+	"application/pdf":             true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+// StoredSize returns the number of bytes the content of the file takes in storage
+func (fs FileService) StoredSize(fileProperties models.File) (int64, error) {
+	info, err := fs.backend.Stat(fs.blobKey(fileProperties))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// ContentSha256 hashes the content of the file as it reads back
+func (fs FileService) ContentSha256(fileProperties models.File) (string, error) {
+	content, err := fs.OpenFile(fileProperties)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	contentHash := sha256.New()
+	_, err = io.Copy(contentHash, content)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(contentHash.Sum(nil)), nil
+}
+
+// ContentSize returns the size of the content of the file as it reads back,
+// after decryption and decompression
+func (fs FileService) ContentSize(fileProperties models.File) (int64, error) {
+	info, err := fs.store.Stat(fs.blobKey(fileProperties))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (fs FileService) DeleteStagedFile(fileProperties models.File) error {
+	err := os.Remove(fs.stagedFileLocation(fileProperties))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (fs FileService) DeleteFile(fileProperties models.File) error {
+	return fs.store.Delete(fs.blobKey(fileProperties))
+}
+
+// DeleteUnreferencedContent deletes the content of files no record points to
+// any more in the background, such as files purged from the trash or versions
+// dropped by the retention. Shared content is deleted under the blob lock and
+// only once it is still unreferenced then, as a commit could have reused it
+// since the files were released.
+func (fs FileService) DeleteUnreferencedContent(files []models.File) {
+	if len(files) == 0 {
+		return
+	}
+	go func() {
+		fs.blobLock.Lock()
+		defer fs.blobLock.Unlock()
+		for _, file := range files {
+			if file.Sha256 != "" {
+				workspaceName, _, _ := strings.Cut(file.Location, "/")
+				referenced, err := fs.references.IsBlobReferenced(workspaceName, file.Sha256)
+				if err != nil || referenced {
+					// Left to garbage collection when it cannot be told
+					continue
+				}
+			}
+			err := fs.DeleteFile(file)
+			if err != nil && !errors.As(err, &apierrors.BlobNotFound{}) {
+				log.Default().Println(err.Error())
+			}
+		}
+	}()
+}
+
+func (fs FileService) CopyFile(sourceProperties models.File, destinationProperties models.File) error {
+	sourceKey := fs.blobKey(sourceProperties)
+	info, err := fs.store.Stat(sourceKey)
+	if err != nil {
+		return err
+	}
+	source, err := fs.store.Get(sourceKey, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return fs.store.Put(fs.blobKey(destinationProperties), source, info.Size)
+}
+
+// RotateWorkspaceKey makes a new data key the active one of the workspace.
+// New blobs use it right away, existing ones with ReencryptWorkspace.
+func (fs FileService) RotateWorkspaceKey(workspaceName string) (int, error) {
+	if !fs.encryptedStore.encryptWrites {
+		return 0, apierrors.EncryptionKeyUnavailable{WorkspaceName: workspaceName}
+	}
+	return fs.keyRing.Rotate(workspaceName)
+}
+
+// ReencryptWorkspace rewrites every blob of the workspace that is not
+// encrypted with the active data key. Blobs are replaced whole, so they stay
+// readable throughout.
+func (fs FileService) ReencryptWorkspace(workspaceName string) error {
+	activeVersion, _, err := fs.keyRing.ActiveDataKey(workspaceName)
+	if err != nil {
+		return err
+	}
+	blobs, err := fs.store.List(workspaceName + "/")
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		version, err := fs.encryptedStore.KeyVersion(blob.Key)
 		if err != nil {
+			log.Default().Println(err.Error())
+			continue
+		}
+		if version == activeVersion {
+			continue
+		}
+		err = fs.reencryptBlob(blob.Key)
+		if err != nil && !errors.As(err, &apierrors.BlobNotFound{}) {
 			log.Default().Println(err.Error())
 			return err
 		}
 	}
-	err := os.Remove(fs.getFileLocation(fileProperties))
+	return nil
+}
+
+// reencryptBlob rewrites the blob below compression, so compressed blobs stay compressed
+func (fs FileService) reencryptBlob(key string) error {
+	info, err := fs.encryptedStore.Stat(key)
 	if err != nil {
-		log.Default().Println(err.Error())
 		return err
 	}
-	return nil
+	content, err := fs.encryptedStore.Get(key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return fs.encryptedStore.Put(key, content, info.Size)
 }
@@ -0,0 +1,211 @@
+package fileservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+// DataKeyStore persists the wrapped data keys of workspaces
+type DataKeyStore interface {
+	GetDataKeys(workspaceName string) ([]models.DataKey, error)
+	AddDataKey(workspaceName string, dataKey models.DataKey) (bool, error)
+}
+
+// KeyRing hands out the data keys of workspaces. Data keys are generated per
+// workspace and only ever stored wrapped by the master key. The newest
+// version is used for writing, older versions stay readable.
+type KeyRing struct {
+	masterKey []byte
+	store     DataKeyStore
+	lock      *sync.Mutex
+	dataKeys  map[string]map[int][]byte
+}
+
+func NewKeyRing(masterKey []byte, store DataKeyStore) *KeyRing {
+	return &KeyRing{
+		masterKey: masterKey,
+		store:     store,
+		lock:      &sync.Mutex{},
+		dataKeys:  map[string]map[int][]byte{},
+	}
+}
+
+// loadMasterKey reads the base64 encoded 256 bit master key from MASTER_KEY
+// or from the file at MASTER_KEY_FILE. It returns nil when neither is set.
+func loadMasterKey() ([]byte, error) {
+	encodedKey := os.Getenv("MASTER_KEY")
+	if keyFile := os.Getenv("MASTER_KEY_FILE"); encodedKey == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encodedKey = strings.TrimSpace(string(data))
+	}
+	if encodedKey == "" {
+		return nil, nil
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(masterKey) != 32 {
+		return nil, errors.New("master key has to be 32 bytes")
+	}
+	return masterKey, nil
+}
+
+// wrappingData binds a wrapped key to its workspace and version, so it cannot
+// be swapped for the key of another workspace
+func wrappingData(workspaceName string, version int) []byte {
+	return []byte(workspaceName + "/" + strconv.Itoa(version))
+}
+
+func (kr *KeyRing) masterCipher(workspaceName string) (cipher.AEAD, error) {
+	if kr.masterKey == nil {
+		return nil, apierrors.EncryptionKeyUnavailable{WorkspaceName: workspaceName}
+	}
+	block, err := aes.NewCipher(kr.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadDataKeys unwraps every data key of the workspace. The caller must hold the lock.
+func (kr *KeyRing) loadDataKeys(workspaceName string) (map[int][]byte, error) {
+	masterCipher, err := kr.masterCipher(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	storedKeys, err := kr.store.GetDataKeys(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	dataKeys := map[int][]byte{}
+	for _, storedKey := range storedKeys {
+		nonceSize := masterCipher.NonceSize()
+		if len(storedKey.WrappedKey) < nonceSize {
+			return nil, apierrors.EncryptionKeyUnavailable{WorkspaceName: workspaceName}
+		}
+		dataKey, err := masterCipher.Open(nil, storedKey.WrappedKey[:nonceSize], storedKey.WrappedKey[nonceSize:], wrappingData(workspaceName, storedKey.Version))
+		if err != nil {
+			// Keys wrapped by another master key cannot be used
+			return nil, apierrors.EncryptionKeyUnavailable{WorkspaceName: workspaceName}
+		}
+		dataKeys[storedKey.Version] = dataKey
+	}
+	kr.dataKeys[workspaceName] = dataKeys
+	return dataKeys, nil
+}
+
+// addDataKey generates and stores the next data key version of the
+// workspace. The caller must hold the lock.
+func (kr *KeyRing) addDataKey(workspaceName string) (int, []byte, error) {
+	masterCipher, err := kr.masterCipher(workspaceName)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for {
+		dataKeys, err := kr.loadDataKeys(workspaceName)
+		if err != nil {
+			return 0, nil, err
+		}
+		version := latestVersion(dataKeys) + 1
+
+		dataKey := make([]byte, 32)
+		nonce := make([]byte, masterCipher.NonceSize())
+		if _, err := rand.Read(dataKey); err != nil {
+			return 0, nil, err
+		}
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, nil, err
+		}
+		wrappedKey := masterCipher.Seal(nonce, nonce, dataKey, wrappingData(workspaceName, version))
+
+		added, err := kr.store.AddDataKey(workspaceName, models.DataKey{
+			Version:    version,
+			WrappedKey: wrappedKey,
+			CreatedOn:  time.Now().UTC(),
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		// Another server added the same version first, so trying with the next one
+		if !added {
+			continue
+		}
+		dataKeys[version] = dataKey
+		return version, dataKey, nil
+	}
+}
+
+func latestVersion(dataKeys map[int][]byte) int {
+	latest := 0
+	for version := range dataKeys {
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// DataKey returns the data key of the workspace with the version
+func (kr *KeyRing) DataKey(workspaceName string, version int) ([]byte, error) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	if dataKey, found := kr.dataKeys[workspaceName][version]; found {
+		return dataKey, nil
+	}
+	// The key could have been added by another server since it was loaded
+	dataKeys, err := kr.loadDataKeys(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, found := dataKeys[version]
+	if !found {
+		return nil, apierrors.EncryptionKeyUnavailable{WorkspaceName: workspaceName}
+	}
+	return dataKey, nil
+}
+
+// ActiveDataKey returns the newest data key of the workspace, creating the
+// first one when the workspace has none
+func (kr *KeyRing) ActiveDataKey(workspaceName string) (int, []byte, error) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	dataKeys, found := kr.dataKeys[workspaceName]
+	if !found {
+		var err error
+		dataKeys, err = kr.loadDataKeys(workspaceName)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if version := latestVersion(dataKeys); version != 0 {
+		return version, dataKeys[version], nil
+	}
+	return kr.addDataKey(workspaceName)
+}
+
+// Rotate makes a new data key the active one of the workspace
+func (kr *KeyRing) Rotate(workspaceName string) (int, error) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	version, _, err := kr.addDataKey(workspaceName)
+	return version, err
+}
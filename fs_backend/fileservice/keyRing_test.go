@@ -0,0 +1,202 @@
+package fileservice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+// memoryDataKeyStore keeps wrapped data keys like the database does, refusing
+// a version that is already there
+type memoryDataKeyStore struct {
+	lock sync.Mutex
+	keys map[string][]models.DataKey
+}
+
+func newMemoryDataKeyStore() *memoryDataKeyStore {
+	return &memoryDataKeyStore{keys: map[string][]models.DataKey{}}
+}
+
+func (ms *memoryDataKeyStore) GetDataKeys(workspaceName string) ([]models.DataKey, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return append([]models.DataKey{}, ms.keys[workspaceName]...), nil
+}
+
+func (ms *memoryDataKeyStore) AddDataKey(workspaceName string, dataKey models.DataKey) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, existing := range ms.keys[workspaceName] {
+		if existing.Version == dataKey.Version {
+			return false, nil
+		}
+	}
+	ms.keys[workspaceName] = append(ms.keys[workspaceName], dataKey)
+	return true, nil
+}
+
+func newTestMasterKey(t *testing.T) []byte {
+	t.Helper()
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		t.Fatal(err)
+	}
+	return masterKey
+}
+
+func TestKeyRingVersions(t *testing.T) {
+	keyRing := NewKeyRing(newTestMasterKey(t), newMemoryDataKeyStore())
+
+	// The first data key is created on first use
+	version, firstKey, err := keyRing.ActiveDataKey("ws")
+	if err != nil || version != 1 || len(firstKey) != 32 {
+		t.Fatalf("got version %d, key of %d bytes and error %v, want version 1", version, len(firstKey), err)
+	}
+	version, again, err := keyRing.ActiveDataKey("ws")
+	if err != nil || version != 1 || !bytes.Equal(again, firstKey) {
+		t.Fatalf("active key changed without a rotation")
+	}
+
+	rotated, err := keyRing.Rotate("ws")
+	if err != nil || rotated != 2 {
+		t.Fatalf("got version %d and error %v after rotating, want version 2", rotated, err)
+	}
+	version, secondKey, err := keyRing.ActiveDataKey("ws")
+	if err != nil || version != 2 || bytes.Equal(secondKey, firstKey) {
+		t.Fatalf("got version %d after rotating, want a new key at version 2", version)
+	}
+
+	tests := []struct {
+		name      string
+		workspace string
+		version   int
+		want      []byte
+	}{
+		{name: "rotated out version", workspace: "ws", version: 1, want: firstKey},
+		{name: "active version", workspace: "ws", version: 2, want: secondKey},
+		{name: "version not created yet", workspace: "ws", version: 3},
+		{name: "other workspace", workspace: "other", version: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataKey, err := keyRing.DataKey(test.workspace, test.version)
+			if test.want == nil {
+				if !errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+					t.Fatalf("got error %v, want the key to be unavailable", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(dataKey, test.want) {
+				t.Fatalf("got key %x and error %v, want %x", dataKey, err, test.want)
+			}
+		})
+	}
+}
+
+func TestKeyRingUnwrap(t *testing.T) {
+	masterKey := newTestMasterKey(t)
+	store := newMemoryDataKeyStore()
+	_, dataKey, err := NewKeyRing(masterKey, store).ActiveDataKey("ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys of one workspace are stored under the name of another
+	swapped := newMemoryDataKeyStore()
+	swapped.keys["other"] = store.keys["ws"]
+
+	tests := []struct {
+		name      string
+		masterKey []byte
+		store     DataKeyStore
+		workspace string
+		ok        bool
+	}{
+		{name: "same master key", masterKey: masterKey, store: store, workspace: "ws", ok: true},
+		{name: "other master key", masterKey: newTestMasterKey(t), store: store, workspace: "ws", ok: false},
+		{name: "no master key", masterKey: nil, store: store, workspace: "ws", ok: false},
+		{name: "key of another workspace", masterKey: masterKey, store: swapped, workspace: "other", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unwrapped, err := NewKeyRing(test.masterKey, test.store).DataKey(test.workspace, 1)
+			if !test.ok {
+				if !errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+					t.Fatalf("got error %v, want the key to be unavailable", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(unwrapped, dataKey) {
+				t.Fatalf("got key %x and error %v, want %x", unwrapped, err, dataKey)
+			}
+		})
+	}
+}
+
+// A key ring with stale keys picks up a rotation done by another server
+func TestKeyRingRotatedElsewhere(t *testing.T) {
+	masterKey := newTestMasterKey(t)
+	store := newMemoryDataKeyStore()
+	keyRing := NewKeyRing(masterKey, store)
+	if _, _, err := keyRing.ActiveDataKey("ws"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyRing(masterKey, store).Rotate("ws"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyRing.DataKey("ws", 2); err != nil {
+		t.Fatalf("key rotated elsewhere is unavailable : %v", err)
+	}
+	// Rotating again goes past the version it has not seen yet
+	version, err := keyRing.Rotate("ws")
+	if err != nil || version != 3 {
+		t.Fatalf("got version %d and error %v, want version 3", version, err)
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	masterKey := newTestMasterKey(t)
+	encoded := base64.StdEncoding.EncodeToString(masterKey)
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		keyFile string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "neither set", want: nil},
+		{name: "from the variable", key: encoded, want: masterKey},
+		{name: "from the file", keyFile: keyFile, want: masterKey},
+		{name: "variable wins over the file", key: encoded, keyFile: "missing", want: masterKey},
+		{name: "missing file", keyFile: filepath.Join(t.TempDir(), "missing"), wantErr: true},
+		{name: "not base64", key: "not base64!", wantErr: true},
+		{name: "short key", key: base64.StdEncoding.EncodeToString(masterKey[:16]), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MASTER_KEY", test.key)
+			t.Setenv("MASTER_KEY_FILE", test.keyFile)
+			got, err := loadMasterKey()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("got %x, want %x", got, test.want)
+			}
+		})
+	}
+}
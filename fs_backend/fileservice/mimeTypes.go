@@ -0,0 +1,126 @@
+package fileservice
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fs_backend/models"
+)
+
+// Types of common extensions, so detection does not depend on the mime.types
+// files of the host
+var extensionTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".tsv":  "text/tab-separated-values; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".xml":  "application/xml",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".svg":  "image/svg+xml",
+	".sql":  "application/sql",
+	".log":  "text/plain; charset=utf-8",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".epub": "application/epub+zip",
+	".jar":  "application/java-archive",
+	".apk":  "application/vnd.android.package-archive",
+	".7z":   "application/x-7z-compressed",
+	".tar":  "application/x-tar",
+	".exe":  "application/vnd.microsoft.portable-executable",
+	".dll":  "application/vnd.microsoft.portable-executable",
+	".msi":  "application/x-msdownload",
+	".sh":   "application/x-sh",
+}
+
+// TypeByExtension returns the type the name of the file suggests, "" when it
+// suggests none
+func TypeByExtension(name string) string {
+	extension := strings.ToLower(filepath.Ext(name))
+	if contentType, found := extensionTypes[extension]; found {
+		return contentType
+	}
+	return mime.TypeByExtension(extension)
+}
+
+// recognizedByContent tells whether content of the type is recognized when
+// sniffed. A name claiming such a type for content that sniffs as something
+// else is not believed.
+func recognizedByContent(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "font/"):
+		return true
+	}
+	switch mediaType {
+	case "application/pdf", "application/zip", "application/x-gzip", "application/gzip", "application/wasm",
+		"application/x-rar-compressed", "application/vnd.rar", "application/ogg":
+		return true
+	}
+	return false
+}
+
+// DetectMimeType works out the type of the content from its first bytes, and
+// from the name where they are not telling. Zip containers and plain text are
+// refined by the extension, so documents and JSON get their own types.
+func DetectMimeType(name string, sample []byte) string {
+	sniffed := http.DetectContentType(sample)
+	sniffedType, _, _ := mime.ParseMediaType(sniffed)
+	byExtension := TypeByExtension(name)
+	extensionType, _, _ := mime.ParseMediaType(byExtension)
+	if byExtension == "" || extensionType == sniffedType {
+		return sniffed
+	}
+
+	switch sniffedType {
+	case "application/octet-stream":
+		if !recognizedByContent(extensionType) {
+			return byExtension
+		}
+	case "text/plain":
+		if !recognizedByContent(extensionType) && !strings.HasPrefix(extensionType, "application/vnd.") {
+			return byExtension
+		}
+	case "application/zip":
+		if strings.HasSuffix(extensionType, "+zip") || strings.HasPrefix(extensionType, "application/vnd.") || extensionType == "application/java-archive" {
+			return byExtension
+		}
+	case "text/xml":
+		if strings.HasSuffix(extensionType, "+xml") || extensionType == "application/xml" {
+			return byExtension
+		}
+	}
+	return sniffed
+}
+
+// DetectStagedMimeType detects the type of an upload from what has been staged
+// of it so far
+func (fs FileService) DetectStagedMimeType(fileProperties models.File) (string, error) {
+	file, err := os.Open(fs.stagedFileLocation(fileProperties))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// http.DetectContentType considers at most 512 bytes
+	sample := make([]byte, 512)
+	n, err := file.ReadAt(sample, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return DetectMimeType(fileProperties.Name, sample[:n]), nil
+}
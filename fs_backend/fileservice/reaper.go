@@ -0,0 +1,217 @@
+package fileservice
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"fs_backend/apierrors"
+)
+
+// BlobReferenceStore tells which blob keys are still used by file records
+type BlobReferenceStore interface {
+	GetReferencedBlobKeys() (map[string]bool, error)
+	IsBlobReferenced(workspaceName string, sha256 string) (bool, error)
+}
+
+// UploadSessionStore tells which staged uploads still have a session. Expired
+// sessions are dropped by it, so their staged files become orphans.
+type UploadSessionStore interface {
+	GetStagedFileIds() (map[string]bool, error)
+}
+
+// ReapReport describes what a garbage collection run found and removed
+type ReapReport struct {
+	StartedOn      time.Time `json:"startedOn"`
+	FinishedOn     time.Time `json:"finishedOn"`
+	DryRun         bool      `json:"dryRun"`
+	OrphanBlobs    []string  `json:"orphanBlobs"`
+	OrphanUploads  []string  `json:"orphanUploads"`
+	FreedBytes     int64     `json:"freedBytes"`
+	SkippedInGrace int       `json:"skippedInGrace"`
+	Errors         []string  `json:"errors"`
+}
+
+// reaper removes blobs no file record points to and staged uploads whose
+// session is gone. Anything younger than the grace period is left alone, as
+// it may belong to an upload being committed.
+type reaper struct {
+	sessions    UploadSessionStore
+	gracePeriod time.Duration
+	runLock     sync.Mutex
+	lastReport  *ReapReport
+	stop        chan struct{}
+}
+
+// StartReaper runs garbage collection every GC_INTERVAL, an hour by default.
+// GC_GRACE_PERIOD sets how old an orphan has to be to be removed, a day by
+// default. An interval of 0 only allows runs on demand.
+func (fs *FileService) StartReaper(sessions UploadSessionStore) {
+	interval := ParseDurationEnv("GC_INTERVAL", time.Hour)
+	fs.reaper = &reaper{
+		sessions:    sessions,
+		gracePeriod: ParseDurationEnv("GC_GRACE_PERIOD", 24*time.Hour),
+		stop:        make(chan struct{}),
+	}
+	if interval == 0 {
+		return
+	}
+
+	go func(r *reaper) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report, err := fs.Reap(false)
+				if err != nil {
+					log.Default().Println("Garbage collection failed :", err.Error())
+					continue
+				}
+				log.Default().Printf("Garbage collection removed %d blobs and %d uploads, freeing %d bytes\n",
+					len(report.OrphanBlobs), len(report.OrphanUploads), report.FreedBytes)
+			case <-r.stop:
+				return
+			}
+		}
+	}(fs.reaper)
+}
+
+// ParseDurationEnv reads a duration from the environment variable, falling back
+// when it is unset or invalid
+func ParseDurationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Default().Println("Invalid duration in", name, ", using", fallback)
+		return fallback
+	}
+	return duration
+}
+
+func (fs FileService) StopReaper() {
+	if fs.reaper != nil {
+		close(fs.reaper.stop)
+	}
+}
+
+// HoldBlobs keeps blobs from being deleted, by garbage collection or once
+// unreferenced, until the returned function is called
+func (fs FileService) HoldBlobs() func() {
+	fs.blobLock.RLock()
+	return fs.blobLock.RUnlock
+}
+
+// LastReapReport returns the report of the latest run, nil before the first one
+func (fs FileService) LastReapReport() *ReapReport {
+	if fs.reaper == nil {
+		return nil
+	}
+	fs.reaper.runLock.Lock()
+	defer fs.reaper.runLock.Unlock()
+	return fs.reaper.lastReport
+}
+
+// Reap runs garbage collection once. A dry run only reports the orphans.
+func (fs FileService) Reap(dryRun bool) (ReapReport, error) {
+	if fs.reaper == nil {
+		return ReapReport{}, errors.New("garbage collection is not started")
+	}
+	fs.reaper.runLock.Lock()
+	defer fs.reaper.runLock.Unlock()
+
+	report := ReapReport{
+		StartedOn:     time.Now().UTC(),
+		DryRun:        dryRun,
+		OrphanBlobs:   []string{},
+		OrphanUploads: []string{},
+		Errors:        []string{},
+	}
+	err := fs.reapUploads(&report)
+	if err != nil {
+		return ReapReport{}, err
+	}
+	err = fs.reapBlobs(&report)
+	if err != nil {
+		return ReapReport{}, err
+	}
+	report.FinishedOn = time.Now().UTC()
+	fs.reaper.lastReport = &report
+	return report, nil
+}
+
+func (fs FileService) reapUploads(report *ReapReport) error {
+	stagedFileIds, err := fs.reaper.sessions.GetStagedFileIds()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(fs.stagingLocation)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || stagedFileIds[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since listing
+			continue
+		}
+		if time.Since(info.ModTime()) < fs.reaper.gracePeriod {
+			report.SkippedInGrace++
+			continue
+		}
+		if !report.DryRun {
+			err = os.Remove(filepath.Join(fs.stagingLocation, entry.Name()))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.OrphanUploads = append(report.OrphanUploads, entry.Name())
+		report.FreedBytes += info.Size()
+	}
+	return nil
+}
+
+func (fs FileService) reapBlobs(report *ReapReport) error {
+	blobs, err := fs.backend.List("")
+	if err != nil {
+		return err
+	}
+
+	// References are read under the lock so no commit can start using a blob
+	// that is about to be deleted
+	fs.blobLock.Lock()
+	defer fs.blobLock.Unlock()
+	referencedKeys, err := fs.references.GetReferencedBlobKeys()
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if referencedKeys[blob.Key] {
+			continue
+		}
+		if time.Since(blob.LastModified) < fs.reaper.gracePeriod {
+			report.SkippedInGrace++
+			continue
+		}
+		if !report.DryRun {
+			err = fs.backend.Delete(blob.Key)
+			if err != nil && !errors.As(err, &apierrors.BlobNotFound{}) {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.OrphanBlobs = append(report.OrphanBlobs, blob.Key)
+		report.FreedBytes += blob.Size
+	}
+	return nil
+}
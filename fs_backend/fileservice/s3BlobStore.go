@@ -0,0 +1,139 @@
+package fileservice
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"fs_backend/apierrors"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3BlobStore keeps blobs as objects of a bucket in any S3 compatible storage
+type S3BlobStore struct {
+	ctx    context.Context
+	client *minio.Client
+	bucket string
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+func NewS3BlobStore(config S3Config) (*S3BlobStore, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ss := &S3BlobStore{
+		ctx:    context.Background(),
+		client: client,
+		bucket: config.Bucket,
+	}
+
+	// Creating the bucket on first use
+	exists, err := client.BucketExists(ss.ctx, config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = client.MakeBucket(ss.ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// mapError converts missing objects to the error used by every blob store
+func (ss *S3BlobStore) mapError(key string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return apierrors.BlobNotFound{Key: key}
+	}
+	log.Default().Println(err.Error())
+	return err
+}
+
+func (ss *S3BlobStore) Put(key string, reader io.Reader, size int64) error {
+	_, err := ss.client.PutObject(ss.ctx, ss.bucket, key, reader, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (ss *S3BlobStore) Get(key string, offset int64, length int64) (io.ReadCloser, error) {
+	options := minio.GetObjectOptions{}
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		options.SetRange(offset, offset+length-1)
+	} else if offset > 0 {
+		options.SetRange(offset, 0)
+	}
+	object, err := ss.client.GetObject(ss.ctx, ss.bucket, key, options)
+	if err != nil {
+		return nil, ss.mapError(key, err)
+	}
+
+	// Objects are fetched lazily, so a missing key only shows up on stat
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, ss.mapError(key, err)
+	}
+	return object, nil
+}
+
+func (ss *S3BlobStore) Delete(key string) error {
+	// Removing a missing object succeeds in S3, so it is checked first
+	_, err := ss.Stat(key)
+	if err != nil {
+		return err
+	}
+	err = ss.client.RemoveObject(ss.ctx, ss.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return ss.mapError(key, err)
+	}
+	return nil
+}
+
+func (ss *S3BlobStore) Stat(key string) (BlobInfo, error) {
+	info, err := ss.client.StatObject(ss.ctx, ss.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return BlobInfo{}, ss.mapError(key, err)
+	}
+	return BlobInfo{Key: key, Size: info.Size, LastModified: info.LastModified}, nil
+}
+
+func (ss *S3BlobStore) List(prefix string) ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+	objects := ss.client.ListObjects(ss.ctx, ss.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			log.Default().Println(object.Err.Error())
+			return nil, object.Err
+		}
+		blobs = append(blobs, BlobInfo{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+	}
+	return blobs, nil
+}
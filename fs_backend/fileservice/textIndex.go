@@ -0,0 +1,56 @@
+package fileservice
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"fs_backend/models"
+)
+
+// Largest content indexed for search when CONTENT_INDEX_MAX_BYTES is not set
+const defaultTextIndexLimit = 1024 * 1024
+
+// readTextIndexLimit reads how large text content can be to be indexed. The
+// index keeps the text in the clear, so nothing is indexed when blobs are
+// encrypted.
+func readTextIndexLimit(encryptionEnabled bool) int64 {
+	if encryptionEnabled {
+		return 0
+	}
+	value := os.Getenv("CONTENT_INDEX_MAX_BYTES")
+	if value == "" {
+		return defaultTextIndexLimit
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		log.Default().Println("Invalid size in CONTENT_INDEX_MAX_BYTES , using", defaultTextIndexLimit)
+		return defaultTextIndexLimit
+	}
+	return limit
+}
+
+// ExtractText returns the content of the file when it is UTF-8 text within the
+// index limit, and "" for anything else
+func (fs FileService) ExtractText(fileProperties models.File) (string, error) {
+	if fileProperties.Size == 0 || int64(fileProperties.Size) > fs.textIndexLimit {
+		return "", nil
+	}
+	content, err := fs.OpenFile(fileProperties)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	text, err := io.ReadAll(io.LimitReader(content, fs.textIndexLimit))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(text) || bytes.IndexByte(text, 0) != -1 {
+		return "", nil
+	}
+	return string(text), nil
+}
@@ -1,25 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"log"
 	"math"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"fs_backend/apierrors"
+	"fs_backend/fileservice"
 	"fs_backend/models"
 
 	"github.com/google/uuid"
 )
 
+// Items in a page of a directory listing that follows a cursor without a
+// limit, and the most a page can hold
+const (
+	defaultListingLimit = 100
+	maxListingLimit     = 1000
+)
+
 func (apifn *ApiConfig) HandleDirectoryQuery(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
-	// Enforcing only GET, PUT and DELETE methods
-	if (req.Method != http.MethodGet) && (req.Method != http.MethodPut) && (req.Method != http.MethodDelete) {
+	// Enforcing only GET, PUT, PATCH and DELETE methods
+	if (req.Method != http.MethodGet) && (req.Method != http.MethodPut) && (req.Method != http.MethodPatch) && (req.Method != http.MethodDelete) {
 		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
@@ -63,13 +79,49 @@ func (apifn *ApiConfig) HandleDirectoryQuery(res http.ResponseWriter, req *http.
 			}
 		}
 
-		// Getting the directory and its contents
-		directoryAndContents, err := apifn.graphService.GetDirectoryAndContentDetails(location)
+		// Reading the listing options, everything is listed by name by default.
+		// Clients that do not page send neither a limit nor a cursor.
+		options := models.ListingOptions{
+			SortBy:     query.Get("sortBy"),
+			Descending: query.Get("order") == "desc",
+			Type:       query.Get("type"),
+			NamePrefix: query.Get("prefix"),
+			Tag:        query.Get("tag"),
+			Cursor:     query.Get("cursor"),
+		}
+		if options.Cursor != "" {
+			options.Limit = defaultListingLimit
+		}
+		if options.SortBy == "" {
+			options.SortBy = "name"
+		}
+		if options.SortBy != "name" && options.SortBy != "size" && options.SortBy != "createdOn" {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		if options.Type != "" && options.Type != "file" && options.Type != "directory" {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		if limit := query.Get("limit"); limit != "" {
+			options.Limit, err = strconv.Atoi(limit)
+			if err != nil || options.Limit < 1 || options.Limit > maxListingLimit {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Getting the directory and a page of its contents
+		directoryAndContents, err := apifn.graphService.GetDirectoryAndContentDetails(location, options)
 		if err != nil {
 			if errors.Is(err, apierrors.DirectoryNotFound{}) {
 				ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
 				return
 			}
+			if errors.Is(err, apierrors.InvalidListingCursor{}) {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+				return
+			}
 			log.Default().Println(err.Error())
 			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 			return
@@ -141,6 +193,71 @@ func (apifn *ApiConfig) HandleDirectoryQuery(res http.ResponseWriter, req *http.
 		JsonResponseWriter(res, resData, http.StatusCreated)
 		return
 	}
+	if req.Method == http.MethodPatch {
+		// Owner doesn't need to check for permissions
+		if workspaceOwner.Id != claims.AccountId {
+			nearestRole, err := apifn.getResolvedRole(claims.AccountId, location)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+			if !nearestRole.CanRename {
+				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+		}
+
+		if len(locationSplit) == 1 {
+			// Cannot rename root
+			ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+			return
+		}
+
+		// Parsing the request body
+		var params struct {
+			NewName string `json:"newName"`
+		}
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		// Checking whether the data is valid
+		if params.NewName == "" || strings.Contains(params.NewName, "/") {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		newLocation, err := apifn.graphService.RenameDirectory(location, params.NewName)
+		if err != nil {
+			if errors.Is(err, apierrors.DirectoryNotFound{}) {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+				return
+			}
+			if errors.As(err, &apierrors.DirectoryWithSameNameAlreadyExists{}) {
+				ErrorResponseWriter(res, apierrors.ResErrDirAlreadyExists, http.StatusBadRequest)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
+		directory, err := apifn.graphService.GetDirectoryDetails(newLocation)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
+		resData := make(map[string]any)
+		resData["directory"] = directory
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
 	if req.Method == http.MethodDelete {
 		// Owner doesn't need to check for permissions
 		if workspaceOwner.Id != claims.AccountId {
@@ -169,6 +286,81 @@ func (apifn *ApiConfig) HandleDirectoryQuery(res http.ResponseWriter, req *http.
 			return
 		}
 
+		recursive := query.Get("recursive") == "true"
+		dryRun := query.Get("dryRun") == "true"
+
+		if recursive || dryRun {
+			items, err := apifn.graphService.GetSubtree(location)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+			if len(items) == 0 {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+				return
+			}
+			// Directory with contents cannot be deleted without recursion
+			if !recursive && len(items) > 1 {
+				ErrorResponseWriter(res, apierrors.ResErrDirNotEmpty, http.StatusBadRequest)
+				return
+			}
+
+			// Checking permissions for every item so roles deeper in the tree are honoured
+			allowed, err := apifn.allowedForSubtree(claims, workspaceOwner.Id, location, items, func(role models.Role) bool {
+				return role.CanDelete
+			})
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+
+			files := []models.File{}
+			totalBytes := 0
+			for _, item := range items {
+				if file, isFile := item.(models.File); isFile {
+					files = append(files, file)
+					totalBytes += file.Size
+				}
+			}
+
+			if dryRun {
+				roleAttachments, err := apifn.graphService.GetRoleAttachmentsInSubtree(location)
+				if err != nil {
+					log.Default().Println(err.Error())
+					ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+					return
+				}
+				resData := make(map[string]any)
+				resData["files"] = files
+				resData["directoryCount"] = len(items) - len(files)
+				resData["totalBytes"] = totalBytes
+				resData["roleAttachments"] = roleAttachments
+				JsonResponseWriter(res, resData, http.StatusOK)
+				return
+			}
+
+			// The directory goes to the trash, its content is kept until purged
+			entry, err := apifn.trashItem(location, claims.AccountId)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+
+			resData := make(map[string]any)
+			resData["deletedFiles"] = len(files)
+			resData["deletedBytes"] = totalBytes
+			resData["trashEntry"] = entry
+			JsonResponseWriter(res, resData, http.StatusOK)
+			return
+		}
+
 		// Checking whether the directory has content
 		count, err := apifn.graphService.CountDirectoryContents(location)
 		if err != nil {
@@ -181,21 +373,22 @@ func (apifn *ApiConfig) HandleDirectoryQuery(res http.ResponseWriter, req *http.
 			ErrorResponseWriter(res, apierrors.ResErrDirNotEmpty, http.StatusBadRequest)
 			return
 		}
-		// Deleting the directory
-		err = apifn.graphService.DeleteDirectory(location)
+		// Moving the directory to the trash
+		entry, err := apifn.trashItem(location, claims.AccountId)
 		if err != nil {
 			log.Default().Println(err.Error())
 			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 			return
 		}
 		resData := make(map[string]any)
+		resData["trashEntry"] = entry
 		JsonResponseWriter(res, resData, http.StatusOK)
 		return
 	}
 }
 
 func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
-	if (req.Method != http.MethodGet) && (req.Method != http.MethodPost) && (req.Method != http.MethodDelete) {
+	if (req.Method != http.MethodGet) && (req.Method != http.MethodPost) && (req.Method != http.MethodPatch) && (req.Method != http.MethodDelete) {
 		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
@@ -221,7 +414,22 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 	}
 
 	if req.Method == http.MethodGet {
-		file, err := apifn.graphService.GetFileDetails(location)
+		// Checking permissions
+		if workspaceOwner.Id != claims.AccountId {
+			nearestRole, err := apifn.getResolvedRole(claims.AccountId, location)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+			if !nearestRole.CanRead {
+				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+		}
+
+		// A version other than the current one can be downloaded too
+		file, err := apifn.getFileAtVersion(location, query.Get("version"))
 		if err != nil {
 			if errors.Is(err, apierrors.FileNotFound{}) {
 				ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
@@ -237,8 +445,15 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 			LinkId:         uuid.New().String(),
 			FileProperties: file,
 			LinkGenerated:  time.Now(),
+			AccountId:      claims.AccountId,
+			WorkspaceName:  workspaceName,
+			IsDownload:     true,
+		}
+		err = apifn.transferPropsService.Set(downloadProperties)
+		if err != nil {
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
 		}
-		apifn.transferPropsService.Set(downloadProperties)
 		resData := map[string]any{}
 		resData["chunkSize"] = apifn.fileService.DownloadChunkSize
 		resData["chunkTotal"] = chunkTotal
@@ -249,8 +464,9 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 	if req.Method == http.MethodPost {
 		// Parsing the request body
 		var params struct {
-			Name string `json:"name"`
-			Size int    `json:"size"`
+			Name   string `json:"name"`
+			Size   int    `json:"size"`
+			Sha256 string `json:"sha256"`
 		}
 		decoder := json.NewDecoder(req.Body)
 		err := decoder.Decode(&params)
@@ -267,22 +483,15 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 		}
 
 		// Checking permissions
-		if workspaceOwner.Id != claims.AccountId {
-			nearestRoles, err := apifn.graphService.GetNearestRole(claims.AccountId, location)
-			if err != nil {
-				log.Default().Println(err.Error())
-				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
-				return
-			}
-			if len(nearestRoles) == 0 {
-				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
-				return
-			}
-			nearestRole := resolveRoles(nearestRoles)
-			if !(nearestRole.CanCreate) {
-				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
-				return
-			}
+		allowed, exists, err := apifn.allowedToUpload(claims.AccountId, workspaceOwner.Id, location+"/"+params.Name)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
 		}
 
 		if params.Size == 0 {
@@ -290,6 +499,29 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 			return
 		}
 
+		// The checksum of the whole file is optional, but has to be a SHA-256 hex digest
+		params.Sha256 = strings.ToLower(params.Sha256)
+		if digest, err := hex.DecodeString(params.Sha256); err != nil || (params.Sha256 != "" && len(digest) != 32) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		// Refusing the upload up front when it would not fit in the quota
+		addedFiles := int64(1)
+		if exists {
+			addedFiles = 0
+		}
+		err = apifn.checkQuota(workspaceName, claims.AccountId, int64(params.Size), addedFiles)
+		if err != nil {
+			if errors.As(err, &apierrors.QuotaExceeded{}) {
+				ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+				return
+			}
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
 		newFileId := uuid.New().String()
 
 		// Creating upload properties for internal use
@@ -303,15 +535,83 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 				Size:      params.Size,
 				Location:  location + "/" + params.Name,
 			},
-			LinkGenerated: time.Now(),
+			LinkGenerated:  time.Now(),
+			AccountId:      claims.AccountId,
+			WorkspaceName:  workspaceName,
+			ExpectedSha256: params.Sha256,
 		}
 
 		// Storing upload details
-		apifn.transferPropsService.Set(uploadProperties)
+		err = apifn.transferPropsService.Set(uploadProperties)
+		if err != nil {
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
 
 		// Sending the upload link
 		resData := make(map[string]any)
 		resData["uploadLink"] = uploadProperties.LinkId
+		resData["chunkSize"] = apifn.fileService.UploadChunkSize
+		resData["chunkTotal"] = apifn.fileService.UploadChunkTotal(params.Size)
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+	if req.Method == http.MethodPatch {
+		// Checking permissions
+		if workspaceOwner.Id != claims.AccountId {
+			nearestRole, err := apifn.getResolvedRole(claims.AccountId, location)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+			if !nearestRole.CanRename {
+				ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+		}
+
+		// Parsing the request body
+		var params struct {
+			NewName string `json:"newName"`
+		}
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		// Checking whether the data is valid
+		if params.NewName == "" || strings.Contains(params.NewName, "/") {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		newLocation, err := apifn.graphService.RenameFile(location, params.NewName)
+		if err != nil {
+			if errors.Is(err, apierrors.FileNotFound{}) {
+				ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+				return
+			}
+			if errors.As(err, &apierrors.FileWithSameNameAlreadyExists{}) {
+				ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusBadRequest)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
+		file, err := apifn.graphService.GetFileDetails(newLocation)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
+		resData := make(map[string]any)
+		resData["file"] = file
 		JsonResponseWriter(res, resData, http.StatusOK)
 		return
 	}
@@ -335,29 +635,26 @@ func (apifn *ApiConfig) HandleFileQuery(res http.ResponseWriter, req *http.Reque
 			}
 		}
 
-		fileFromDb, err := apifn.graphService.GetFileDetails(location)
+		_, err := apifn.graphService.GetFileDetails(location)
 		if err != nil {
 			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
 			return
 		}
 
-		err = apifn.graphService.DeleteFile(location)
+		// Moving the file to the trash
+		_, err = apifn.trashItem(location, claims.AccountId)
 		if err != nil {
 			log.Default().Println(err.Error())
 			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
 		}
 
-		// Deleting the file from storage
-		go func() {
-			apifn.fileService.DeleteFileFromInternalLocation(fileFromDb)
-		}()
-
 		res.WriteHeader(http.StatusOK)
 	}
 }
 
-func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Request, _ models.JWTData) {
-	if req.Method != http.MethodPost {
+func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodPost && req.Method != http.MethodGet && req.Method != http.MethodHead {
 		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
@@ -369,7 +666,7 @@ func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Reque
 	properties, err := apifn.transferPropsService.Get(uploadId)
 	if err != nil {
 		log.Default().Println(err.Error())
-		if errors.Is(err, apierrors.UploadIdNotFound{}) {
+		if errors.Is(err, apierrors.UploadIdNotFound{UploadId: uploadId}) {
 			ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusBadRequest)
 			return
 		}
@@ -377,6 +674,38 @@ func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Reque
 		return
 	}
 
+	// Sessions created through tus can only be continued through tus
+	if properties.Protocol != "" || properties.IsDownload {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusBadRequest)
+		return
+	}
+
+	// Only the account that created the session can use it
+	if properties.AccountId != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	chunkTotal := apifn.fileService.UploadChunkTotal(properties.FileProperties.Size)
+
+	// Reporting the upload status so clients can resume
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		res.Header().Set("Chunk-Size", strconv.Itoa(apifn.fileService.UploadChunkSize))
+		res.Header().Set("Chunk-Total", strconv.Itoa(chunkTotal))
+		res.Header().Set("Chunk-Received", strconv.Itoa(properties.ReceivedChunks.Count()))
+		if req.Method == http.MethodHead {
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+		resData := make(map[string]any)
+		resData["chunkSize"] = apifn.fileService.UploadChunkSize
+		resData["chunkTotal"] = chunkTotal
+		resData["missingChunks"] = properties.ReceivedChunks.Missing(chunkTotal)
+		resData["completed"] = properties.Completed
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+
 	// Reading header
 	chunkTotalStr := req.Header.Get("Chunk-Total")
 	chunkCurrentStr := req.Header.Get("Chunk-Current")
@@ -386,48 +715,156 @@ func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	chunkTotal, err := strconv.Atoi(chunkTotalStr)
-	if err != nil {
+	// The client has to split the file with the same chunk size as the server
+	chunkTotalHeader, err := strconv.Atoi(chunkTotalStr)
+	if err != nil || chunkTotalHeader != chunkTotal {
 		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
 		return
 	}
 	chunkCurrent, err := strconv.Atoi(chunkCurrentStr)
-	if err != nil {
+	if err != nil || chunkCurrent < 1 || chunkCurrent > chunkTotal {
 		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
 		return
 	}
 
-	var chunkData struct {
-		Data string `json:"data"`
-	}
-	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&chunkData)
-	if err != nil {
-		log.Default().Println(err)
-		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
-		return
+	// The checksum of the chunk is optional
+	var expectedChunkDigest []byte
+	if digestHeader := req.Header.Get("Chunk-Digest"); digestHeader != "" {
+		var valid bool
+		expectedChunkDigest, valid = parseSha256Digest(digestHeader)
+		if !valid {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
 	}
 
-	data, err := base64.StdEncoding.DecodeString(chunkData.Data)
-	if err != nil {
-		log.Default().Println(err)
-		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
-		return
-	}
+	// Retries of an already received chunk are accepted without writing again
+	if !properties.ReceivedChunks.Has(chunkCurrent) {
+		chunkLength := apifn.fileService.UploadChunkLength(properties.FileProperties.Size, chunkCurrent)
+		chunkOffset := int64(apifn.fileService.UploadChunkSize) * int64(chunkCurrent-1)
+		body := bufio.NewReader(req.Body)
 
-	err = apifn.fileService.WriteChunkToFile(properties.FileProperties, data)
-	if err != nil {
-		log.Default().Println(err.Error())
-		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
-		return
+		chunk, isRawChunk, err := readUploadChunk(req, body, chunkLength)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+
+		var chunkHash hash.Hash
+		if expectedChunkDigest != nil {
+			chunkHash = sha256.New()
+			chunk = io.TeeReader(chunk, chunkHash)
+		}
+
+		// The file checksum is computed while writing when the chunk continues the
+		// hashed part of the file. Other chunks are hashed from disk on commit.
+		var contentHash hash.Hash
+		hashClaimed := false
+		defer func() {
+			if hashClaimed {
+				apifn.transferPropsService.Release(uploadId)
+			}
+		}()
+		if chunkOffset == properties.HashedBytes && apifn.transferPropsService.Claim(uploadId) {
+			hashClaimed = true
+			contentHash, err = apifn.fileService.ResumeContentHash(properties.HashState)
+			if err == nil {
+				chunk = io.TeeReader(chunk, contentHash)
+			}
+		}
+
+		written, err := apifn.fileService.WriteChunkToFile(properties.FileProperties, chunkCurrent, chunk)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		// A chunk cut short is not recorded, so it is reported as missing. So is a
+		// raw chunk of unknown length that turns out longer than expected.
+		if written != int64(chunkLength) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		if isRawChunk {
+			if _, err := body.Peek(1); err == nil {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+				return
+			}
+		}
+		if chunkHash != nil && !bytes.Equal(chunkHash.Sum(nil), expectedChunkDigest) {
+			ErrorResponseWriter(res, apierrors.ResErrChecksumMismatch, http.StatusBadRequest)
+			return
+		}
+
+		if contentHash != nil {
+			hashState, err := apifn.fileService.SaveContentHash(contentHash)
+			if err == nil {
+				_, err = apifn.transferPropsService.SetContentHash(uploadId, hashState, chunkOffset+written)
+			}
+			if err != nil {
+				log.Default().Println(err.Error())
+			}
+		}
+		// Releasing before the chunk is recorded so whoever records the last chunk can commit
+		if hashClaimed {
+			apifn.transferPropsService.Release(uploadId)
+			hashClaimed = false
+		}
+
+		// Recording the chunk so the session can be resumed
+		properties, err = apifn.transferPropsService.AddReceivedChunk(uploadId, chunkCurrent)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+
+		// The first chunk tells the type, so a refused file is not sent in full
+		if chunkCurrent == 1 {
+			_, err = apifn.checkUploadMimeType(properties)
+			if errors.As(err, &apierrors.MimeTypeNotAllowed{}) {
+				apifn.rejectUpload(properties)
+				ErrorResponseWriter(res, apierrors.ResErrMimeTypeNotAllowed, http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				log.Default().Println(err.Error())
+			}
+		}
 	}
 
 	resData := make(map[string]any)
-	if chunkCurrent == chunkTotal {
+	resData["receivedChunks"] = properties.ReceivedChunks.Count()
+	if properties.Completed {
+		resData["newFile"] = properties.FileProperties
+	} else if properties.ReceivedChunks.Count() == chunkTotal && apifn.transferPropsService.Claim(uploadId) {
+		defer apifn.transferPropsService.Release(uploadId)
+
 		// If upload has completed, then create a record in database
-		err := apifn.graphService.CreateFile(properties.FileProperties)
+		properties, err = apifn.commitUpload(uploadId)
 		if err != nil {
 			log.Default().Println(err.Error())
+			if errors.As(err, &apierrors.ChecksumMismatch{}) {
+				ErrorResponseWriter(res, apierrors.ResErrChecksumMismatch, http.StatusBadRequest)
+				return
+			}
+			if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+				ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+				return
+			}
+			if errors.As(err, &apierrors.QuotaExceeded{}) {
+				ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+				return
+			}
+			if errors.As(err, &apierrors.MimeTypeNotAllowed{}) {
+				ErrorResponseWriter(res, apierrors.ResErrMimeTypeNotAllowed, http.StatusUnsupportedMediaType)
+				return
+			}
+			if errors.As(err, &apierrors.FileWithSameNameAlreadyExists{}) {
+				ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusBadRequest)
+				return
+			}
 			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 			return
 		}
@@ -437,18 +874,178 @@ func (apifn ApiConfig) handleFileUpload(res http.ResponseWriter, req *http.Reque
 	JsonResponseWriter(res, resData, http.StatusOK)
 }
 
-func (apifn ApiConfig) handleFileDownload(res http.ResponseWriter, req *http.Request, _ models.JWTData) {
+// readUploadChunk returns the chunk in the body of an upload request, and
+// whether it is raw. Raw chunks are streamed straight to disk. Older clients
+// send the chunk base64 encoded in a JSON body, labelled as octet-stream too.
+// Such a body is always longer than the chunk, so a body of another length
+// starting with a brace is taken as JSON.
+func readUploadChunk(req *http.Request, body *bufio.Reader, chunkLength int) (io.Reader, bool, error) {
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	isJsonChunk := contentType == "application/json"
+	if !isJsonChunk && req.ContentLength >= 0 && req.ContentLength != int64(chunkLength) {
+		firstByte, err := body.Peek(1)
+		if err != nil || firstByte[0] != '{' {
+			return nil, false, fmt.Errorf("chunk of %d bytes instead of %d", req.ContentLength, chunkLength)
+		}
+		isJsonChunk = true
+	}
+	if !isJsonChunk {
+		return io.LimitReader(body, int64(chunkLength)), true, nil
+	}
+
+	var chunkData struct {
+		Data string `json:"data"`
+	}
+	decoder := json.NewDecoder(body)
+	err := decoder.Decode(&chunkData)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(chunkData.Data)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) != chunkLength {
+		return nil, false, fmt.Errorf("chunk of %d bytes instead of %d", len(data), chunkLength)
+	}
+	return bytes.NewReader(data), false, nil
+}
+
+// commitUpload creates the record of a fully received upload. The caller must
+// hold the claim of the session.
+func (apifn ApiConfig) commitUpload(uploadId string) (models.FileTransferProperties, error) {
+	// Reading the session again as another request could have committed it meanwhile
+	properties, err := apifn.transferPropsService.Get(uploadId)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	if properties.Completed {
+		return properties, nil
+	}
+
+	// Making sure the stored file is exactly what the client announced
+	stagedSize, err := apifn.fileService.StagedFileSize(properties.FileProperties)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	if stagedSize != int64(properties.FileProperties.Size) {
+		return models.FileTransferProperties{}, fmt.Errorf("upload %s has %d bytes stored instead of %d", uploadId, stagedSize, properties.FileProperties.Size)
+	}
+
+	// Hashing whatever was not hashed while the chunks were written
+	contentHash, err := apifn.fileService.ResumeContentHash(properties.HashState)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	err = apifn.fileService.HashStagedRange(properties.FileProperties, contentHash, properties.HashedBytes, stagedSize-properties.HashedBytes)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	properties.FileProperties.Sha256 = hex.EncodeToString(contentHash.Sum(nil))
+
+	// The received data cannot be trusted when the client's checksum differs, so
+	// the upload has to start over
+	if properties.ExpectedSha256 != "" && properties.ExpectedSha256 != properties.FileProperties.Sha256 {
+		apifn.transferPropsService.Delete(uploadId)
+		apifn.fileService.DeleteStagedFile(properties.FileProperties)
+		return models.FileTransferProperties{}, apierrors.ChecksumMismatch{FileName: properties.FileProperties.Name}
+	}
+
+	// The whole upload is staged, so the type detected now is final
+	mimeType, err := apifn.checkUploadMimeType(properties)
+	if err != nil {
+		if errors.As(err, &apierrors.MimeTypeNotAllowed{}) {
+			apifn.rejectUpload(properties)
+		}
+		return models.FileTransferProperties{}, err
+	}
+	properties.FileProperties.MimeType = mimeType
+
+	// Another account could have created the file since this session was, and
+	// replacing it takes the permission to delete it
+	workspaceName := properties.WorkspaceName
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	allowed, exists, err := apifn.allowedToUpload(properties.AccountId, workspaceOwner.Id, properties.FileProperties.Location)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	if exists && !allowed {
+		location := properties.FileProperties.Location
+		return models.FileTransferProperties{}, apierrors.FileWithSameNameAlreadyExists{
+			ParentDirName: location[:strings.LastIndex(location, "/")],
+			FileName:      properties.FileProperties.Name,
+		}
+	}
+
+	// Other uploads could have used up the quota since this session was created.
+	// The staged file is kept so the commit can be retried once space is freed.
+	// A new version of an existing file does not add to the file count. The
+	// space is held until the record is created, so concurrent commits cannot
+	// take it too.
+	addedFiles := int64(1)
+	if exists {
+		addedFiles = 0
+	}
+	releaseQuota, err := apifn.reserveQuota(workspaceName, properties.AccountId, int64(properties.FileProperties.Size), addedFiles)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	defer releaseQuota()
+	settings, err := apifn.graphService.GetWorkspaceSettings(workspaceName)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	properties.FileProperties.UploadedBy = properties.AccountId
+	releaseBlobs := apifn.fileService.HoldBlobs()
+	defer releaseBlobs()
+	stored, err := apifn.fileService.StoreStagedFile(properties.FileProperties, settings.Compression)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	storedSize, err := apifn.fileService.StoredSize(properties.FileProperties)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	properties.FileProperties.StoredSize = int(storedSize)
+	file, unreferencedFiles, err := apifn.graphService.CreateFile(properties.FileProperties, settings.VersionRetention)
+	if err != nil {
+		// The staged file is kept so the commit can be retried. The stored blob
+		// goes unless another commit has started using it meanwhile.
+		if stored {
+			apifn.fileService.DeleteUnreferencedContent([]models.File{properties.FileProperties})
+		}
+		return models.FileTransferProperties{}, err
+	}
+	apifn.fileService.DeleteStagedFile(properties.FileProperties)
+	go apifn.indexFileText(file)
+
+	// Deleting the content of versions dropped by the retention
+	apifn.fileService.DeleteUnreferencedContent(unreferencedFiles)
+
+	// Uploading to an existing file answers with that file at its new version
+	properties.FileProperties = file
+
+	// Keeping the completed session until it expires so retries get the same answer
+	properties.Completed = true
+	err = apifn.transferPropsService.Set(properties)
+	if err != nil {
+		log.Default().Println(err.Error())
+	}
+	return properties, nil
+}
+
+func (apifn ApiConfig) handleFileDownload(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
 	if req.Method != http.MethodGet {
 		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Getting the upload ID from the url path
+	// Getting the download ID from the url path
 	downloadId := strings.TrimPrefix(req.URL.Path, "/fs/download/")
 
-	// Getting upload details
-	apifn.transferPropsService.Get(downloadId)
-
 	chunkTotalStr := req.Header.Get("Chunk-Total")
 	chunkCurrentStr := req.Header.Get("Chunk-Current")
 
@@ -474,17 +1071,31 @@ func (apifn ApiConfig) handleFileDownload(res http.ResponseWriter, req *http.Req
 	properties, err := apifn.transferPropsService.Get(downloadId)
 	if err != nil {
 		log.Default().Println(err.Error())
-		if errors.Is(err, apierrors.UploadIdNotFound{}) {
+		if errors.As(err, &apierrors.UploadIdNotFound{}) {
 			ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusBadRequest)
 			return
 		}
 		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 		return
 	}
+	if !properties.IsDownload {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusBadRequest)
+		return
+	}
+
+	// Only the account that created the session can use it
+	if properties.AccountId != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
 
 	chunk, err := apifn.fileService.ReadChunkFromFile(properties.FileProperties, chunkCurrent)
 	if err != nil {
 		log.Default().Println(err)
+		if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+			ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+			return
+		}
 		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 		return
 	}
@@ -493,6 +1104,9 @@ func (apifn ApiConfig) handleFileDownload(res http.ResponseWriter, req *http.Req
 		log.Default().Println("Chunk Total")
 	}
 
+	// A chunk is a piece of the file, whose type comes with the session
+	res.Header().Set("Content-Type", "application/octet-stream")
+	setChunkChecksumHeaders(res, properties.FileProperties, chunk)
 	res.WriteHeader(200)
 	res.Write(chunk)
 }
@@ -519,6 +1133,9 @@ func (apifn ApiConfig) HandleFSShared(res http.ResponseWriter, req *http.Request
 	JsonResponseWriter(res, resData, http.StatusOK)
 }
 
+// handleDirDetailsQuery returns the directory with the size of everything below
+// it and its roles. Only the owner gets them, as the size counts items other
+// accounts may not be able to read.
 func (apifn ApiConfig) handleDirDetailsQuery(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
 	location := req.URL.Query().Get("location")
 
@@ -551,6 +1168,14 @@ func (apifn ApiConfig) handleDirDetailsQuery(res http.ResponseWriter, req *http.
 		return
 	}
 
+	// Adding up what is below the directory
+	size, err := apifn.graphService.GetDirectorySize(location)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
 	// Getting the roles
 	roles, err := apifn.graphService.GetItemAllRoles(location)
 	if err != nil {
@@ -561,6 +1186,7 @@ func (apifn ApiConfig) handleDirDetailsQuery(res http.ResponseWriter, req *http.
 
 	resData := make(map[string]any)
 	resData["directory"] = dir
+	resData["size"] = size
 	resData["roles"] = roles
 	JsonResponseWriter(res, resData, http.StatusOK)
 }
@@ -608,3 +1234,457 @@ func (apifn ApiConfig) handleFileDetailsQuery(res http.ResponseWriter, req *http
 	resData["getRolesCypher"] = roles
 	JsonResponseWriter(res, resData, http.StatusOK)
 }
+
+func (apifn ApiConfig) HandleFSMove(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodPost {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parsing the request body
+	var params struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+	}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether the data is valid
+	if params.Source == "" || params.Destination == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	sourceSplit := strings.Split(params.Source, "/")
+	destinationSplit := strings.Split(params.Destination, "/")
+	// Root cannot be moved and items cannot leave the workspace
+	if len(sourceSplit) == 1 || sourceSplit[0] != destinationSplit[0] {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	workspaceName := sourceSplit[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	items, err := apifn.graphService.GetSubtree(params.Source)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if len(items) == 0 {
+		ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	// Checking permissions for removing every item from the source
+	allowed, err := apifn.allowedForSubtree(claims, workspaceOwner.Id, params.Source, items, func(role models.Role) bool {
+		return role.CanDelete && role.CanRename
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	// Checking permissions for creating in the destination
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, params.Destination)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanCreate {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	var newLocation string
+	if _, isDirectory := items[0].(models.Directory); isDirectory {
+		newLocation, err = apifn.graphService.MoveDirectory(params.Source, params.Destination)
+	} else {
+		newLocation, err = apifn.graphService.MoveFile(params.Source, params.Destination)
+	}
+	if err != nil {
+		if errors.As(err, &apierrors.InvalidDestination{}) || errors.As(err, &apierrors.DirectoryNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+			return
+		}
+		if errors.As(err, &apierrors.DirectoryWithSameNameAlreadyExists{}) {
+			ErrorResponseWriter(res, apierrors.ResErrDirAlreadyExists, http.StatusBadRequest)
+			return
+		}
+		if errors.As(err, &apierrors.FileWithSameNameAlreadyExists{}) {
+			ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, apierrors.FileNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	resData["location"] = newLocation
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+func (apifn ApiConfig) HandleFSCopy(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodPost {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parsing the request body
+	var params struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		NewName     string `json:"newName"`
+	}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether the data is valid
+	if params.Source == "" || params.Destination == "" || strings.Contains(params.NewName, "/") {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	sourceSplit := strings.Split(params.Source, "/")
+	destinationSplit := strings.Split(params.Destination, "/")
+	// Root cannot be copied and items cannot leave the workspace
+	if len(sourceSplit) == 1 || sourceSplit[0] != destinationSplit[0] {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	// A directory cannot be copied inside itself
+	if params.Destination == params.Source || strings.HasPrefix(params.Destination, params.Source+"/") {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	workspaceName := sourceSplit[0]
+	if params.NewName == "" {
+		params.NewName = sourceSplit[len(sourceSplit)-1]
+	}
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	items, err := apifn.graphService.GetSubtree(params.Source)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if len(items) == 0 {
+		ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	// Checking permissions for reading every item of the source
+	allowed, err := apifn.allowedForSubtree(claims, workspaceOwner.Id, params.Source, items, func(role models.Role) bool {
+		return role.CanRead
+	})
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	// Checking permissions for creating in the destination
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, params.Destination)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanCreate {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Planning the copies with new ids and locations
+	topLocation := params.Destination + "/" + params.NewName
+	createdOn := time.Now().UTC()
+	copies := []models.ItemCopy{}
+	sourceFiles := []models.File{}
+	copiedFiles := []models.File{}
+	var copiedBytes, copiedFileCount int64
+	for _, item := range items {
+		newLocation := topLocation + strings.TrimPrefix(itemLocation(item), params.Source)
+		itemCopy := models.ItemCopy{
+			Id:             uuid.New().String(),
+			SourceLocation: itemLocation(item),
+			Location:       newLocation,
+			CreatedOn:      createdOn,
+			UploadedBy:     claims.AccountId,
+		}
+		switch i := item.(type) {
+		case models.Directory:
+			itemCopy.Type = "directory"
+		case models.File:
+			itemCopy.Type = "file"
+			copiedBytes += int64(i.Size)
+			copiedFileCount++
+			// Content addressed blobs are shared by the copies, only older files are duplicated
+			if i.Sha256 != "" {
+				break
+			}
+			copiedFile := i
+			copiedFile.Id = itemCopy.Id
+			copiedFile.Location = newLocation
+			sourceFiles = append(sourceFiles, i)
+			copiedFiles = append(copiedFiles, copiedFile)
+		}
+		copies = append(copies, itemCopy)
+	}
+
+	// Copies count towards the quota like uploads, even when they share blobs
+	releaseQuota, err := apifn.reserveQuota(workspaceName, claims.AccountId, copiedBytes, copiedFileCount)
+	if err != nil {
+		if errors.As(err, &apierrors.QuotaExceeded{}) {
+			ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	defer releaseQuota()
+
+	// Duplicating the blobs before the records so no record points to a missing blob
+	for index := range sourceFiles {
+		err = apifn.fileService.CopyFile(sourceFiles[index], copiedFiles[index])
+		if err != nil {
+			log.Default().Println(err.Error())
+			go func(copiedFiles []models.File) {
+				for _, copiedFile := range copiedFiles {
+					apifn.fileService.DeleteFile(copiedFile)
+				}
+			}(copiedFiles[:index+1])
+			if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+				ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = apifn.graphService.CopyItems(params.Destination, copies)
+	if err != nil {
+		go func() {
+			for _, copiedFile := range copiedFiles {
+				apifn.fileService.DeleteFile(copiedFile)
+			}
+		}()
+		if errors.As(err, &apierrors.DirectoryNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+			return
+		}
+		if errors.As(err, &apierrors.DirectoryWithSameNameAlreadyExists{}) {
+			ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusBadRequest)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	resData["location"] = topLocation
+	JsonResponseWriter(res, resData, http.StatusCreated)
+}
+
+// HandleFileContent streams a file on a stable URL with support for Range,
+// conditional requests and caching headers, so any HTTP client can use it.
+// Besides the Authorization header, it accepts a content token in the query,
+// so browsers can use the URL as the source of a media element or a download.
+func (apifn ApiConfig) HandleFileContent(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if token := query.Get("token"); token != "" {
+		claims, err := apifn.parseContentToken(token, query.Get("location"))
+		if err != nil {
+			ErrorResponseWriter(res, apierrors.ResErrTokenInvalid, http.StatusBadRequest)
+			return
+		}
+		apifn.serveFileContent(res, req, claims)
+		return
+	}
+	apifn.authMiddleware(apifn.serveFileContent)(res, req)
+}
+
+// HandleFileContentToken issues a content token for the file at the location
+// to an account that can read it
+func (apifn ApiConfig) HandleFileContentToken(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	location := req.URL.Query().Get("location")
+
+	// Checking whether the data is valid
+	if location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	workspaceName := strings.Split(location, "/")[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Checking permissions
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanRead {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	expiresOn := time.Now().UTC().Add(contentTokenLifetime)
+	token, err := apifn.generateContentToken(claims.AccountId, location, expiresOn)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	resData["token"] = token
+	resData["expiresOn"] = expiresOn
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+// serveFileContent streams the file after checking the account can read it,
+// which also covers tokens issued before the permission was taken away
+func (apifn ApiConfig) serveFileContent(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	location := query.Get("location")
+
+	// Checking whether the data is valid
+	if location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+
+	locationSplit := strings.Split(location, "/")
+	workspaceName := locationSplit[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Checking permissions
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanRead {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	file, err := apifn.getFileAtVersion(location, query.Get("version"))
+	if err != nil {
+		if errors.Is(err, apierrors.FileNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	content, err := apifn.fileService.OpenFile(file)
+	if err != nil {
+		if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+			ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// Files from before type detection are served by their extension
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = fileservice.TypeByExtension(file.Name)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if query.Get("inline") == "true" {
+		disposition = "inline"
+	}
+
+	// Sniffed types such as HTML are served as they are, so browsers must
+	// neither guess another type nor run scripts of a file shown inline
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Content-Security-Policy", "sandbox")
+	res.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}))
+	setChecksumHeaders(res, file)
+	res.Header().Set("Cache-Control", "private, no-cache")
+
+	// Handles Range, If-Range, If-None-Match, If-Modified-Since and HEAD
+	http.ServeContent(res, req, file.Name, file.ModifiedOn, content)
+}
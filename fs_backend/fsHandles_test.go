@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadUploadChunk(t *testing.T) {
+	chunk := []byte("{chunk content}")
+	jsonBody := `{"data":"` + base64.StdEncoding.EncodeToString(chunk) + `"}`
+
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		contentLength int64
+		raw           bool
+		ok            bool
+	}{
+		{name: "raw", contentType: "application/octet-stream", body: string(chunk), raw: true, ok: true},
+		{name: "raw of unknown length", contentType: "application/octet-stream", body: string(chunk), contentLength: -1, raw: true, ok: true},
+		{name: "raw without a content type", contentType: "", body: string(chunk), raw: true, ok: true},
+		{name: "json", contentType: "application/json", body: jsonBody, raw: false, ok: true},
+		{name: "json labelled as octet-stream", contentType: "application/octet-stream", body: jsonBody, raw: false, ok: true},
+		{name: "short raw", contentType: "application/octet-stream", body: string(chunk[1:]), ok: false},
+		{name: "long raw", contentType: "application/octet-stream", body: string(chunk) + "more", ok: false},
+		{name: "json of another length", contentType: "application/json", body: `{"data":"` + base64.StdEncoding.EncodeToString(chunk[1:]) + `"}`, ok: false},
+		{name: "json that is not base64", contentType: "application/octet-stream", body: `{"data":"not base64!"}`, ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/fs/upload/id", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			if test.contentLength != 0 {
+				req.ContentLength = test.contentLength
+			}
+			got, raw, err := readUploadChunk(req, bufio.NewReader(req.Body), len(chunk))
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+			if raw != test.raw {
+				t.Errorf("got raw %v, want %v", raw, test.raw)
+			}
+			content, err := io.ReadAll(got)
+			if err != nil || !bytes.Equal(content, chunk) {
+				t.Errorf("got chunk %q and error %v, want %q", content, err, chunk)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+// fsckIssue is an inconsistency between the graph and the blob store
+type fsckIssue struct {
+	kind     string
+	location string
+	detail   string
+	repaired bool
+}
+
+// runFsck checks a workspace for inconsistencies and, with -repair, fixes the
+// ones that can be fixed without losing data. It returns the exit code, 1 when
+// problems are left.
+//
+//	fs_backend fsck -workspace <name> [-repair]
+func runFsck(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "name of the workspace to check")
+	repair := flags.Bool("repair", false, "fix stored sizes, item locations and dangling role edges")
+	flags.Parse(args)
+	if *workspaceName == "" {
+		flags.Usage()
+		return 2
+	}
+
+	apiCfg := ApiConfig{}
+	apiCfg.graphService.Connect()
+	defer apiCfg.graphService.Close()
+	apiCfg.fileService.Initialize(apiCfg.graphService, apiCfg.graphService)
+
+	workspaceExists, err := apiCfg.graphService.CheckWorkspace(*workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return 1
+	}
+	if !workspaceExists {
+		fmt.Println("Workspace", *workspaceName, "not found")
+		return 2
+	}
+
+	issues := []fsckIssue{}
+	checks := []func(string, bool) ([]fsckIssue, error){
+		apiCfg.checkLocations,
+		apiCfg.checkDuplicateNames,
+		apiCfg.checkRoleEdges,
+		apiCfg.checkBlobs,
+	}
+	for _, check := range checks {
+		found, err := check(*workspaceName, *repair)
+		if err != nil {
+			log.Default().Println(err.Error())
+			return 1
+		}
+		issues = append(issues, found...)
+	}
+
+	unrepaired := 0
+	for _, issue := range issues {
+		status := "FOUND"
+		if issue.repaired {
+			status = "REPAIRED"
+		} else {
+			unrepaired++
+		}
+		fmt.Printf("%-9s %-20s %s : %s\n", status, issue.kind, issue.location, issue.detail)
+	}
+	fmt.Printf("%d problems found in %s, %d repaired\n", len(issues), *workspaceName, len(issues)-unrepaired)
+	if unrepaired != 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkLocations finds items whose location does not match their path. Checked
+// first, so the other checks see repaired locations.
+func (apifn ApiConfig) checkLocations(workspaceName string, repair bool) ([]fsckIssue, error) {
+	mismatches, err := apifn.graphService.GetLocationMismatches(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	issues := []fsckIssue{}
+	for _, mismatch := range mismatches {
+		issue := fsckIssue{
+			kind:     "location-mismatch",
+			location: mismatch.PathLocation,
+			detail:   "recorded as " + mismatch.Location,
+		}
+		if repair {
+			issue.repaired, err = apifn.graphService.FixItemLocation(mismatch)
+			if err != nil {
+				return nil, err
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// checkDuplicateNames finds directories with several items of a name. Which
+// one to keep is up to the owner, so they are only reported.
+func (apifn ApiConfig) checkDuplicateNames(workspaceName string, repair bool) ([]fsckIssue, error) {
+	duplicates, err := apifn.graphService.GetDuplicateNames(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	issues := []fsckIssue{}
+	for _, duplicate := range duplicates {
+		issues = append(issues, fsckIssue{
+			kind:     "duplicate-name",
+			location: duplicate.Location + "/" + duplicate.Name,
+			detail:   fmt.Sprintf("%d items with the name", duplicate.Count),
+		})
+	}
+	return issues, nil
+}
+
+func (apifn ApiConfig) checkRoleEdges(workspaceName string, repair bool) ([]fsckIssue, error) {
+	edges, err := apifn.graphService.GetDanglingRoleEdges(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	issues := []fsckIssue{}
+	for _, edge := range edges {
+		issue := fsckIssue{
+			kind:     "dangling-manages",
+			location: edge.Location,
+			detail:   "role " + edge.RoleName + " is of another workspace",
+		}
+		if repair {
+			err = apifn.graphService.DeleteRoleEdge(edge.EdgeId)
+			if err != nil {
+				return nil, err
+			}
+			issue.repaired = true
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// checkBlobs makes sure every file has a blob holding content of its size.
+// Only the recorded stored size is repaired, content cannot be.
+func (apifn ApiConfig) checkBlobs(workspaceName string, repair bool) ([]fsckIssue, error) {
+	items, err := apifn.graphService.GetSubtree(workspaceName)
+	if err != nil {
+		return nil, err
+	}
+	issues := []fsckIssue{}
+	checked := map[string]bool{}
+	for _, item := range items {
+		file, isFile := item.(models.File)
+		if !isFile || checked[file.Id] {
+			continue
+		}
+		checked[file.Id] = true
+
+		contentSize, err := apifn.fileService.ContentSize(file)
+		if err != nil {
+			if errors.As(err, &apierrors.BlobNotFound{}) {
+				issues = append(issues, fsckIssue{kind: "missing-blob", location: file.Location, detail: "no content stored"})
+				continue
+			}
+			if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+				issues = append(issues, fsckIssue{kind: "unreadable-blob", location: file.Location, detail: err.Error()})
+				continue
+			}
+			return nil, err
+		}
+		if contentSize != int64(file.Size) {
+			issues = append(issues, fsckIssue{
+				kind:     "size-mismatch",
+				location: file.Location,
+				detail:   fmt.Sprintf("recorded %d bytes, stored %d", file.Size, contentSize),
+			})
+			continue
+		}
+
+		storedSize, err := apifn.fileService.StoredSize(file)
+		if err != nil {
+			return nil, err
+		}
+		if storedSize != int64(file.StoredSize) {
+			issue := fsckIssue{
+				kind:     "stored-size-mismatch",
+				location: file.Location,
+				detail:   fmt.Sprintf("recorded %d bytes, stored %d", file.StoredSize, storedSize),
+			}
+			if repair {
+				err = apifn.graphService.SetFileStoredSize(file.Id, storedSize)
+				if err != nil {
+					return nil, err
+				}
+				issue.repaired = true
+			}
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
@@ -12,6 +12,11 @@ func main() {
 	godotenv.Load()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	// Maintenance subcommands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(runFsck(os.Args[2:]))
+	}
+
 	apiCfg := ApiConfig{}
 	apiCfg.initialize()
 	defer apiCfg.close()
@@ -34,17 +39,32 @@ func main() {
 	http.HandleFunc("/ws/check-avl", apiCfg.authMiddleware(apiCfg.handleCheckWorkspaceAvailability))
 	http.HandleFunc("/ws/op", apiCfg.authMiddleware(apiCfg.handleWorkspaceOperations))
 	http.HandleFunc("/ws/account", apiCfg.authMiddleware(apiCfg.handleWorkspaceAccountOperations))
+	http.HandleFunc("/ws/settings", apiCfg.authMiddleware(apiCfg.handleWorkspaceSettings))
+	http.HandleFunc("/ws/usage", apiCfg.authMiddleware(apiCfg.handleWorkspaceUsage))
+	http.HandleFunc("/ws/keys/rotate", apiCfg.authMiddleware(apiCfg.handleWorkspaceKeyRotation))
 	http.HandleFunc("/fs/dir/query", apiCfg.authMiddleware(apiCfg.HandleDirectoryQuery))
 	http.HandleFunc("/fs/file/query", apiCfg.authMiddleware(apiCfg.HandleFileQuery))
+	http.HandleFunc("/fs/file/content", apiCfg.HandleFileContent)
+	http.HandleFunc("/fs/file/content/token", apiCfg.authMiddleware(apiCfg.HandleFileContentToken))
+	http.HandleFunc("/fs/tree", apiCfg.authMiddleware(apiCfg.HandleTree))
 	http.HandleFunc("/fs/dir/details", apiCfg.authMiddleware(apiCfg.handleDirDetailsQuery))
+	http.HandleFunc("/fs/file/versions", apiCfg.authMiddleware(apiCfg.HandleFileVersions))
 	http.HandleFunc("/fs/file/details", apiCfg.authMiddleware(apiCfg.handleFileDetailsQuery))
+	http.HandleFunc("/fs/move", apiCfg.authMiddleware(apiCfg.HandleFSMove))
+	http.HandleFunc("/fs/copy", apiCfg.authMiddleware(apiCfg.HandleFSCopy))
+	http.HandleFunc("/fs/tags", apiCfg.authMiddleware(apiCfg.HandleItemTags))
+	http.HandleFunc("/fs/search", apiCfg.authMiddleware(apiCfg.HandleSearch))
+	http.HandleFunc("/fs/trash", apiCfg.authMiddleware(apiCfg.HandleTrash))
 	http.HandleFunc("/fs/shared/query", apiCfg.authMiddleware(apiCfg.HandleFSShared))
 	http.HandleFunc("/fs/upload/", apiCfg.authMiddleware(apiCfg.handleFileUpload))
+	http.HandleFunc("/fs/tus", apiCfg.HandleTus)
+	http.HandleFunc("/fs/tus/", apiCfg.HandleTus)
 	http.HandleFunc("/fs/download/", apiCfg.authMiddleware(apiCfg.handleFileDownload))
 	http.HandleFunc("/role/op", apiCfg.authMiddleware(apiCfg.HandleRolesOperations))
 	http.HandleFunc("/role/assign", apiCfg.authMiddleware(apiCfg.HandleAssignRoleToSA))
 	http.HandleFunc("/roles/sa", apiCfg.authMiddleware(apiCfg.HandleGetAllAccountRoles))
 	http.HandleFunc("/roles/details", apiCfg.authMiddleware(apiCfg.HandleGetAllRolesInWorkspace))
+	http.HandleFunc("/admin/gc", apiCfg.adminMiddleware(apiCfg.HandleGarbageCollection))
 	http.HandleFunc("/rbac/fs", apiCfg.authMiddleware(apiCfg.HandleGetRoleFSPermissions))
 
 	log.Default().Printf("Server starting at %v \n", server.Addr)
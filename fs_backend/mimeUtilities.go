@@ -0,0 +1,68 @@
+package main
+
+import (
+	"mime"
+	"regexp"
+	"strings"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+var mimeTypePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9!#$&^_.+-]*/(\*|[a-z0-9][a-z0-9!#$&^_.+-]*)$`)
+
+// validMimeTypePatterns checks that every pattern is a type, like image/png,
+// or a whole top level type, like image/*
+func validMimeTypePatterns(patterns []string) bool {
+	for _, pattern := range patterns {
+		if !mimeTypePattern.MatchString(pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesMimeType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// mimeTypeAllowed applies the allow and deny lists of the workspace to the
+// type, ignoring its parameters
+func mimeTypeAllowed(settings models.WorkspaceSettings, mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	if matchesMimeType(settings.DeniedMimeTypes, mediaType) {
+		return false
+	}
+	return len(settings.AllowedMimeTypes) == 0 || matchesMimeType(settings.AllowedMimeTypes, mediaType)
+}
+
+// checkUploadMimeType detects the type of the upload from what is staged of it
+// and fails with MimeTypeNotAllowed when the workspace refuses the type
+func (apifn ApiConfig) checkUploadMimeType(properties models.FileTransferProperties) (string, error) {
+	mimeType, err := apifn.fileService.DetectStagedMimeType(properties.FileProperties)
+	if err != nil {
+		return "", err
+	}
+	settings, err := apifn.graphService.GetWorkspaceSettings(properties.WorkspaceName)
+	if err != nil {
+		return "", err
+	}
+	if !mimeTypeAllowed(settings, mimeType) {
+		return mimeType, apierrors.MimeTypeNotAllowed{MimeType: mimeType}
+	}
+	return mimeType, nil
+}
+
+// rejectUpload ends an upload of a refused type, so none of it is kept
+func (apifn ApiConfig) rejectUpload(properties models.FileTransferProperties) {
+	apifn.transferPropsService.Delete(properties.LinkId)
+	apifn.fileService.DeleteStagedFile(properties.FileProperties)
+}
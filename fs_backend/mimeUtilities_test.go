@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"fs_backend/models"
+)
+
+func TestValidMimeTypePatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		valid    bool
+	}{
+		{patterns: nil, valid: true},
+		{patterns: []string{"image/png", "application/vnd.ms-excel", "image/svg+xml"}, valid: true},
+		{patterns: []string{"image/*"}, valid: true},
+		{patterns: []string{"image/png", "*/*"}, valid: false},
+		{patterns: []string{"image"}, valid: false},
+		{patterns: []string{"image/"}, valid: false},
+		{patterns: []string{"image/png*"}, valid: false},
+		{patterns: []string{"Image/PNG"}, valid: false},
+		{patterns: []string{"text/plain; charset=utf-8"}, valid: false},
+	}
+	for _, test := range tests {
+		if valid := validMimeTypePatterns(test.patterns); valid != test.valid {
+			t.Errorf("validMimeTypePatterns(%q) = %v, want %v", test.patterns, valid, test.valid)
+		}
+	}
+}
+
+func TestMimeTypeAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		denied   []string
+		mimeType string
+		want     bool
+	}{
+		{name: "no lists", mimeType: "application/x-msdownload", want: true},
+		{name: "allowed type", allowed: []string{"image/png"}, mimeType: "image/png", want: true},
+		{name: "type not allowed", allowed: []string{"image/png"}, mimeType: "image/jpeg", want: false},
+		{name: "allowed by prefix", allowed: []string{"image/*"}, mimeType: "image/jpeg", want: true},
+		{name: "prefix of another top level type", allowed: []string{"image/*"}, mimeType: "imagex/jpeg", want: false},
+		{name: "prefix without the type", allowed: []string{"text/*"}, mimeType: "text", want: false},
+		{name: "denied type", denied: []string{"application/x-msdownload"}, mimeType: "application/x-msdownload", want: false},
+		{name: "other type than the denied", denied: []string{"application/x-msdownload"}, mimeType: "text/plain", want: true},
+		{name: "deny wins over allow", allowed: []string{"image/*"}, denied: []string{"image/svg+xml"}, mimeType: "image/svg+xml", want: false},
+		{name: "deny by prefix wins over allow", allowed: []string{"text/html"}, denied: []string{"text/*"}, mimeType: "text/html", want: false},
+		{name: "parameters are ignored", allowed: []string{"text/plain"}, mimeType: "text/plain; charset=utf-8", want: true},
+		{name: "parameters do not escape a deny", denied: []string{"text/html"}, mimeType: "text/html; charset=utf-8", want: false},
+		{name: "type in another case", allowed: []string{"text/plain"}, mimeType: "Text/Plain", want: true},
+		{name: "invalid type as octet-stream", allowed: []string{"application/octet-stream"}, mimeType: "not a type", want: true},
+		{name: "invalid type denied as octet-stream", denied: []string{"application/*"}, mimeType: "not a type", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := models.WorkspaceSettings{AllowedMimeTypes: test.allowed, DeniedMimeTypes: test.denied}
+			if got := mimeTypeAllowed(settings, test.mimeType); got != test.want {
+				t.Errorf("mimeTypeAllowed(%q) = %v, want %v", test.mimeType, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+// ChunkBitmap records which chunks of a transfer have been received. Chunk
+// numbers start from 1 as in the Chunk-Current header.
+type ChunkBitmap []byte
+
+func (cb ChunkBitmap) Has(chunk int) bool {
+	index := chunk - 1
+	if index < 0 || index/8 >= len(cb) {
+		return false
+	}
+	return cb[index/8]&(1<<(index%8)) != 0
+}
+
+func (cb ChunkBitmap) Set(chunk int) ChunkBitmap {
+	index := chunk - 1
+	if index < 0 {
+		return cb
+	}
+	for index/8 >= len(cb) {
+		cb = append(cb, 0)
+	}
+	cb[index/8] |= 1 << (index % 8)
+	return cb
+}
+
+func (cb ChunkBitmap) Count() int {
+	count := 0
+	for _, b := range cb {
+		for ; b != 0; b &= b - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// Missing returns the chunks out of total that have not been received
+func (cb ChunkBitmap) Missing(total int) []int {
+	missing := []int{}
+	for chunk := 1; chunk <= total; chunk++ {
+		if !cb.Has(chunk) {
+			missing = append(missing, chunk)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkBitmapHas(t *testing.T) {
+	var bitmap ChunkBitmap
+	for _, chunk := range []int{1, 8, 9, 20} {
+		bitmap = bitmap.Set(chunk)
+	}
+
+	tests := []struct {
+		chunk int
+		want  bool
+	}{
+		{chunk: 1, want: true},
+		{chunk: 2, want: false},
+		{chunk: 8, want: true},
+		{chunk: 9, want: true},
+		{chunk: 20, want: true},
+		// Chunks past the last word and before the first chunk were never set
+		{chunk: 21, want: false},
+		{chunk: 100, want: false},
+		{chunk: 0, want: false},
+		{chunk: -3, want: false},
+	}
+	for _, test := range tests {
+		if got := bitmap.Has(test.chunk); got != test.want {
+			t.Errorf("Has(%d) = %v, want %v", test.chunk, got, test.want)
+		}
+	}
+}
+
+func TestChunkBitmapSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []int
+		want   ChunkBitmap
+	}{
+		{name: "nothing", chunks: []int{}, want: nil},
+		{name: "first chunk", chunks: []int{1}, want: ChunkBitmap{0x01}},
+		{name: "last chunk of a word", chunks: []int{8}, want: ChunkBitmap{0x80}},
+		{name: "first chunk of the next word", chunks: []int{9}, want: ChunkBitmap{0x00, 0x01}},
+		{name: "same chunk twice", chunks: []int{3, 3}, want: ChunkBitmap{0x04}},
+		{name: "out of range chunk", chunks: []int{0, -1}, want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bitmap ChunkBitmap
+			for _, chunk := range test.chunks {
+				bitmap = bitmap.Set(chunk)
+			}
+			if !reflect.DeepEqual(bitmap, test.want) {
+				t.Errorf("got %08b, want %08b", bitmap, test.want)
+			}
+		})
+	}
+}
+
+func TestChunkBitmapCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []int
+		want   int
+	}{
+		{name: "empty", chunks: []int{}, want: 0},
+		{name: "one word", chunks: []int{1, 2, 3, 4, 5, 6, 7, 8}, want: 8},
+		{name: "partial last word", chunks: []int{1, 9, 10, 17}, want: 4},
+		{name: "repeated chunks", chunks: []int{5, 5, 5}, want: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bitmap ChunkBitmap
+			for _, chunk := range test.chunks {
+				bitmap = bitmap.Set(chunk)
+			}
+			if got := bitmap.Count(); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChunkBitmapMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []int
+		total  int
+		want   []int
+	}{
+		{name: "nothing received", chunks: []int{}, total: 3, want: []int{1, 2, 3}},
+		{name: "everything received", chunks: []int{1, 2, 3}, total: 3, want: []int{}},
+		{name: "gaps", chunks: []int{1, 3, 4}, total: 5, want: []int{2, 5}},
+		// The total does not fill the last word, so its spare bits are ignored
+		{name: "partial last word", chunks: []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, total: 11, want: []int{10, 11}},
+		// Chunks beyond the total do not make up for missing ones
+		{name: "chunks beyond the total", chunks: []int{1, 12}, total: 2, want: []int{2}},
+		{name: "no chunks", chunks: []int{}, total: 0, want: []int{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bitmap ChunkBitmap
+			for _, chunk := range test.chunks {
+				bitmap = bitmap.Set(chunk)
+			}
+			if got := bitmap.Missing(test.total); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
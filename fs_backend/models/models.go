@@ -3,8 +3,62 @@ package models
 import "time"
 
 type Workspace struct {
-	Id   string `json:"id"`
-	Name string `json:"name"`
+	Id    string          `json:"id"`
+	Name  string          `json:"name"`
+	Usage *WorkspaceUsage `json:"usage,omitempty"`
+}
+
+// WorkspaceSettings are chosen by the owner. A quota or retention of 0 means
+// no limit, so trash is then only emptied by hand.
+type WorkspaceSettings struct {
+	Compression        bool  `json:"compression"`
+	QuotaBytes         int64 `json:"quotaBytes"`
+	QuotaFiles         int64 `json:"quotaFiles"`
+	VersionRetention   int64 `json:"versionRetention"`
+	TrashRetentionDays int64 `json:"trashRetentionDays"`
+	// Patterns like image/png or image/*. Uploads matching a denied type are
+	// refused, and with allowed types set, so is anything not matching one.
+	AllowedMimeTypes []string `json:"allowedMimeTypes"`
+	DeniedMimeTypes  []string `json:"deniedMimeTypes"`
+}
+
+type WorkspaceUsage struct {
+	Bytes      int64 `json:"bytes"`
+	Files      int64 `json:"files"`
+	QuotaBytes int64 `json:"quotaBytes"`
+	QuotaFiles int64 `json:"quotaFiles"`
+}
+
+// DataKey is a workspace encryption key, wrapped by the master key
+type DataKey struct {
+	Version    int       `json:"version"`
+	WrappedKey []byte    `json:"-"`
+	CreatedOn  time.Time `json:"createdOn"`
+}
+
+// DirectorySize adds up everything below a directory
+type DirectorySize struct {
+	Bytes       int64 `json:"bytes"`
+	Files       int64 `json:"files"`
+	Directories int64 `json:"directories"`
+}
+
+// UsageShare is the part of a workspace taken by the files sharing a key, a
+// top level item, a file type or an uploading account
+type UsageShare struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// UsageReport breaks the current files of a workspace down, largest first.
+// Versions and trash only count towards the usage.
+type UsageReport struct {
+	Usage       WorkspaceUsage `json:"usage"`
+	ByDirectory []UsageShare   `json:"byDirectory"`
+	ByType      []UsageShare   `json:"byType"`
+	ByUploader  []UsageShare   `json:"byUploader"`
 }
 
 type OwnerAccount struct {
@@ -21,6 +75,7 @@ type ServiceAccount struct {
 	LinkedEmail         string `json:"linkedEmail"`
 	ShouldResetPassword bool   `json:"shouldResetPassword"`
 	Password            string `json:"password"`
+	QuotaBytes          int64  `json:"quotaBytes"`
 }
 
 type Role struct {
@@ -34,20 +89,58 @@ type Role struct {
 }
 
 type Directory struct {
-	Id        string    `json:"id"`
-	Type      string    `json:"type"`
-	Name      string    `json:"name"`
-	Location  string    `json:"location"`
-	CreatedOn time.Time `json:"createdOn"`
+	Id        string            `json:"id"`
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Location  string            `json:"location"`
+	CreatedOn time.Time         `json:"createdOn"`
+	Tags      []string          `json:"tags"`
+	Metadata  map[string]string `json:"metadata"`
 }
 
 type File struct {
-	Id        string    `json:"id"`
-	Type      string    `json:"type"`
-	Name      string    `json:"name"`
-	Size      int       `json:"size"`
-	Location  string    `json:"location"`
-	CreatedOn time.Time `json:"createdOn"`
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`
+	Location   string    `json:"location"`
+	CreatedOn  time.Time `json:"createdOn"`
+	Sha256     string    `json:"sha256"`
+	StoredSize int       `json:"storedSize"`
+	UploadedBy string    `json:"uploadedBy"`
+	Version    int       `json:"version"`
+	ModifiedOn time.Time `json:"modifiedOn"`
+	// Detected from the content on upload, files from before have none
+	MimeType string `json:"mimeType"`
+	// Set by the owner and accounts that can rename the file
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// FileVersion is a content the file had before a later upload replaced it
+type FileVersion struct {
+	Version    int       `json:"version"`
+	Size       int       `json:"size"`
+	Sha256     string    `json:"sha256"`
+	StoredSize int       `json:"storedSize"`
+	UploadedBy string    `json:"uploadedBy"`
+	MimeType   string    `json:"mimeType"`
+	CreatedOn  time.Time `json:"createdOn"`
+	Current    bool      `json:"current"`
+}
+
+// TrashEntry is a deleted item, kept with everything in it until it is
+// restored to its location or purged
+type TrashEntry struct {
+	Id            string    `json:"id"`
+	Type          string    `json:"type"`
+	Name          string    `json:"name"`
+	Location      string    `json:"location"`
+	WorkspaceName string    `json:"workspaceName"`
+	DeletedOn     time.Time `json:"deletedOn"`
+	DeletedBy     string    `json:"deletedBy"`
+	Files         int64     `json:"files"`
+	Bytes         int64     `json:"bytes"`
 }
 
 type DirectoryWithContents struct {
@@ -57,6 +150,66 @@ type DirectoryWithContents struct {
 	CreatedOn time.Time     `json:"createdOn"`
 	Location  string        `json:"location"`
 	Contents  []interface{} `json:"contents"`
+	// Counts of the items matching the filters, across all pages
+	TotalCount     int64 `json:"totalCount"`
+	DirectoryCount int64 `json:"directoryCount"`
+	FileCount      int64 `json:"fileCount"`
+	// Passed back as the cursor to get the next page, empty on the last one
+	NextCursor string `json:"nextCursor"`
+}
+
+// TreeNode is an item of a tree listing. Directories count their direct
+// items, and carry them as children when they are within the depth.
+type TreeNode struct {
+	Item        any         `json:"item"`
+	Directories int64       `json:"directories"`
+	Files       int64       `json:"files"`
+	Bytes       int64       `json:"bytes"`
+	Children    []*TreeNode `json:"children"`
+	Depth       int         `json:"-"`
+}
+
+// SearchOptions narrows a search below a location. Unset bounds are nil and
+// unset strings empty. Results are ordered by location and start after After.
+type SearchOptions struct {
+	Location   string
+	Name       string
+	Match      string
+	Type       string
+	Extension  string
+	MinSize    *int64
+	MaxSize    *int64
+	From       *time.Time
+	To         *time.Time
+	UploadedBy string
+	Content    string
+	Tag        string
+	// Matched only together, when the key is set
+	MetadataKey   string
+	MetadataValue string
+	After         string
+	Limit         int
+}
+
+// ListingOptions selects which items of a directory are listed and in what
+// order. Directories always come before files. A limit of 0 lists everything.
+type ListingOptions struct {
+	SortBy     string
+	Descending bool
+	Type       string
+	NamePrefix string
+	Tag        string
+	Limit      int
+	Cursor     string
+}
+
+type ItemCopy struct {
+	Id             string
+	Type           string
+	SourceLocation string
+	Location       string
+	CreatedOn      time.Time
+	UploadedBy     string
 }
 
 type RoleWithUsers struct {
@@ -64,10 +217,25 @@ type RoleWithUsers struct {
 	ServiceAccounts []ServiceAccount `json:"accounts"`
 }
 
+type RoleAttachment struct {
+	Role     Role   `json:"role"`
+	Location string `json:"location"`
+}
+
 type FileTransferProperties struct {
-	FileProperties File
-	LinkId         string
-	LinkGenerated  time.Time
+	FileProperties File        `json:"fileProperties"`
+	LinkId         string      `json:"linkId"`
+	LinkGenerated  time.Time   `json:"linkGenerated"`
+	AccountId      string      `json:"accountId"`
+	WorkspaceName  string      `json:"workspaceName"`
+	IsDownload     bool        `json:"isDownload"`
+	ReceivedChunks ChunkBitmap `json:"receivedChunks"`
+	Completed      bool        `json:"completed"`
+	Protocol       string      `json:"protocol"`
+	UploadOffset   int64       `json:"uploadOffset"`
+	HashState      []byte      `json:"hashState"`
+	HashedBytes    int64       `json:"hashedBytes"`
+	ExpectedSha256 string      `json:"expectedSha256"`
 }
 
 type JWTData struct {
@@ -79,3 +247,36 @@ type JWTData struct {
 	Username   string
 	IsOwner    bool
 }
+
+// LocationMismatch is an item whose location differs from the names on its
+// CONTAINS path from the root directory
+type LocationMismatch struct {
+	Id           string
+	Location     string
+	PathLocation string
+}
+
+// DanglingRoleEdge is a MANAGES edge between a role and an item of different
+// workspaces
+type DanglingRoleEdge struct {
+	EdgeId   string
+	RoleName string
+	Location string
+}
+
+type DuplicateName struct {
+	Location string
+	Name     string
+	Count    int
+}
+
+// QuotaReservation holds space in the quotas of a workspace for content being
+// committed, until its record is created
+type QuotaReservation struct {
+	Id            string
+	WorkspaceName string
+	AccountId     string
+	Bytes         int64
+	Files         int64
+	CreatedOn     time.Time
+}
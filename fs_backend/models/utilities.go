@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
@@ -18,6 +19,8 @@ func GetOnwerAccountFromRecord(record any) OwnerAccount {
 
 func GetServiceAccountFromRecord(record any) ServiceAccount {
 	att := record.(neo4j.Node).Props
+	// Accounts without a quota have no limit
+	quotaBytes, _ := att["quotaBytes"].(int64)
 	return ServiceAccount{
 		Id:                  att["id"].(string),
 		Username:            att["username"].(string),
@@ -25,6 +28,7 @@ func GetServiceAccountFromRecord(record any) ServiceAccount {
 		LinkedEmail:         att["linkedEmail"].(string),
 		Password:            att["password"].(string),
 		ShouldResetPassword: att["shouldResetPassword"].(bool),
+		QuotaBytes:          quotaBytes,
 	}
 }
 
@@ -36,21 +40,116 @@ func GetDirectoryFromRecord(record any) Directory {
 		Name:      att["name"].(string),
 		Location:  att["location"].(string),
 		CreatedOn: att["createdOn"].(time.Time),
+		Tags:      getTagsFromProps(att),
+		Metadata:  getMetadataFromProps(att),
 	}
 }
 
+// Metadata is kept on the item node, with every key behind this prefix so it
+// cannot clash with the properties of the item
+const MetadataPropertyPrefix = "meta."
+
+func getTagsFromProps(att map[string]any) []string {
+	tags := []string{}
+	if list, found := att["tags"].([]any); found {
+		for _, tag := range list {
+			tags = append(tags, tag.(string))
+		}
+	}
+	return tags
+}
+
+func getMetadataFromProps(att map[string]any) map[string]string {
+	metadata := map[string]string{}
+	for key, value := range att {
+		if name, found := strings.CutPrefix(key, MetadataPropertyPrefix); found {
+			metadata[name], _ = value.(string)
+		}
+	}
+	return metadata
+}
+
 func GetFileFromRecord(record any) File {
 	att := record.(neo4j.Node).Props
+	// Files uploaded before checksums were recorded have none
+	sha256, _ := att["sha256"].(string)
+	// Files stored before compression take their own size
+	storedSize, found := att["storedSize"].(int64)
+	if !found {
+		storedSize = att["size"].(int64)
+	}
+	uploadedBy, _ := att["uploadedBy"].(string)
+	// Files get versions once they are uploaded again
+	version, found := att["version"].(int64)
+	if !found {
+		version = 1
+	}
+	modifiedOn, found := att["modifiedOn"].(time.Time)
+	if !found {
+		modifiedOn = att["createdOn"].(time.Time)
+	}
+	mimeType, _ := att["mimeType"].(string)
 	return File{
-		Id:        att["id"].(string),
-		Type:      "file",
-		Name:      att["name"].(string),
-		Size:      int(att["size"].(int64)),
-		Location:  att["location"].(string),
-		CreatedOn: att["createdOn"].(time.Time),
+		Id:         att["id"].(string),
+		Type:       "file",
+		Name:       att["name"].(string),
+		Size:       int(att["size"].(int64)),
+		Location:   att["location"].(string),
+		CreatedOn:  att["createdOn"].(time.Time),
+		Sha256:     sha256,
+		StoredSize: int(storedSize),
+		UploadedBy: uploadedBy,
+		Version:    int(version),
+		ModifiedOn: modifiedOn,
+		MimeType:   mimeType,
+		Tags:       getTagsFromProps(att),
+		Metadata:   getMetadataFromProps(att),
+	}
+}
+
+func GetFileVersionFromRecord(record any) FileVersion {
+	att := record.(neo4j.Node).Props
+	sha256, _ := att["sha256"].(string)
+	uploadedBy, _ := att["uploadedBy"].(string)
+	mimeType, _ := att["mimeType"].(string)
+	return FileVersion{
+		Version:    int(att["version"].(int64)),
+		Size:       int(att["size"].(int64)),
+		Sha256:     sha256,
+		StoredSize: int(att["storedSize"].(int64)),
+		UploadedBy: uploadedBy,
+		MimeType:   mimeType,
+		CreatedOn:  att["createdOn"].(time.Time),
 	}
 }
 
+// CurrentVersion describes the content the file has now as a version
+func (file File) CurrentVersion() FileVersion {
+	return FileVersion{
+		Version:    file.Version,
+		Size:       file.Size,
+		Sha256:     file.Sha256,
+		StoredSize: file.StoredSize,
+		UploadedBy: file.UploadedBy,
+		MimeType:   file.MimeType,
+		CreatedOn:  file.ModifiedOn,
+		Current:    true,
+	}
+}
+
+// WithVersion returns the file as it was at the version, so its content can
+// be read from storage
+func (file File) WithVersion(version FileVersion) File {
+	file.Size = version.Size
+	file.Sha256 = version.Sha256
+	file.StoredSize = version.StoredSize
+	file.UploadedBy = version.UploadedBy
+	file.MimeType = version.MimeType
+	file.Version = version.Version
+	file.ModifiedOn = version.CreatedOn
+	return file
+}
+
 func GetRoleFromRecord(record any) Role {
 	att := record.(neo4j.Node).Props
 	return Role{
@@ -71,3 +170,39 @@ func GetWorkspaceFromRecord(record any) Workspace {
 		Name: att["name"].(string),
 	}
 }
+
+func GetDataKeyFromRecord(record any) DataKey {
+	att := record.(neo4j.Node).Props
+	return DataKey{
+		Version:    int(att["version"].(int64)),
+		WrappedKey: att["wrappedKey"].([]byte),
+		CreatedOn:  att["createdOn"].(time.Time),
+	}
+}
+
+func GetTrashEntryFromRecord(record any) TrashEntry {
+	att := record.(neo4j.Node).Props
+	return TrashEntry{
+		Id:            att["id"].(string),
+		Type:          att["type"].(string),
+		Name:          att["name"].(string),
+		Location:      att["location"].(string),
+		WorkspaceName: att["workspaceName"].(string),
+		DeletedOn:     att["deletedOn"].(time.Time),
+		DeletedBy:     att["deletedBy"].(string),
+	}
+}
+
+func GetUsageSharesFromRecord(record any) []UsageShare {
+	shares := []UsageShare{}
+	for _, r := range record.([]any) {
+		att := r.(map[string]any)
+		shares = append(shares, UsageShare{
+			Key:   att["key"].(string),
+			Name:  att["name"].(string),
+			Bytes: att["bytes"].(int64),
+			Files: att["files"].(int64),
+		})
+	}
+	return shares
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"fs_backend/models"
+
+	"github.com/google/uuid"
+)
+
+// checkQuota fails with QuotaExceeded when adding the files would take the
+// workspace, or the uploading service account, over its quota. It only turns
+// away what cannot fit early on, commits reserve the space with reserveQuota.
+func (apifn ApiConfig) checkQuota(workspaceName string, accountId string, addedBytes int64, addedFiles int64) error {
+	return apifn.graphService.CheckQuota(workspaceName, accountId, addedBytes, addedFiles)
+}
+
+// reserveQuota holds the space the files take in the quotas until the
+// returned function is called, failing with QuotaExceeded when they do not fit
+// next to what is stored and reserved by other commits
+func (apifn ApiConfig) reserveQuota(workspaceName string, accountId string, addedBytes int64, addedFiles int64) (func(), error) {
+	reservation := models.QuotaReservation{
+		Id:            uuid.New().String(),
+		WorkspaceName: workspaceName,
+		AccountId:     accountId,
+		Bytes:         addedBytes,
+		Files:         addedFiles,
+		CreatedOn:     time.Now().UTC(),
+	}
+	reserved, err := apifn.graphService.ReserveQuota(reservation)
+	if err != nil {
+		return nil, err
+	}
+	if !reserved {
+		return func() {}, nil
+	}
+	return func() {
+		err := apifn.graphService.ReleaseQuota(reservation)
+		if err != nil {
+			log.Default().Println(err.Error())
+		}
+	}, nil
+}
+
+// getWorkspaceUsage returns the usage of the workspace along with its quotas
+func (apifn ApiConfig) getWorkspaceUsage(workspaceName string) (models.WorkspaceUsage, error) {
+	settings, err := apifn.graphService.GetWorkspaceSettings(workspaceName)
+	if err != nil {
+		return models.WorkspaceUsage{}, err
+	}
+	usage, err := apifn.graphService.GetWorkspaceUsage(workspaceName, "")
+	if err != nil {
+		return models.WorkspaceUsage{}, err
+	}
+	usage.QuotaBytes = settings.QuotaBytes
+	usage.QuotaFiles = settings.QuotaFiles
+	return usage, nil
+}
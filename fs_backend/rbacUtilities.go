@@ -1,6 +1,12 @@
 package main
 
-import "fs_backend/models"
+import (
+	"errors"
+	"strings"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
 
 var resolutionMap map[bool]map[bool]bool = map[bool]map[bool]bool{
 	true: {
@@ -26,3 +32,101 @@ func resolveRoles(roles []models.Role) models.Role {
 	}
 	return newRole
 }
+
+// getResolvedRole resolves the nearest roles of the account for the location
+// into a single role. Accounts without any role get a role with no permissions.
+func (apifn ApiConfig) getResolvedRole(accountId string, location string) (models.Role, error) {
+	nearestRoles, err := apifn.graphService.GetNearestRole(accountId, location)
+	if err != nil {
+		return models.Role{}, err
+	}
+	if len(nearestRoles) == 0 {
+		return models.Role{}, nil
+	}
+	return resolveRoles(nearestRoles), nil
+}
+
+// subtreeRoleResolver resolves the role of an account for every item below a
+// location without a query per item. Roles attached deeper in the tree take
+// precedence over the role of the top item, as in GetNearestRole.
+type subtreeRoleResolver struct {
+	location    string
+	role        models.Role
+	assignments map[string][]models.Role
+}
+
+func (apifn ApiConfig) newSubtreeRoleResolver(accountId string, location string) (subtreeRoleResolver, error) {
+	role, err := apifn.getResolvedRole(accountId, location)
+	if err != nil {
+		return subtreeRoleResolver{}, err
+	}
+	assignments, err := apifn.graphService.GetAccountRolesInSubtree(accountId, location)
+	if err != nil {
+		return subtreeRoleResolver{}, err
+	}
+	return subtreeRoleResolver{
+		location:    location,
+		role:        role,
+		assignments: assignments,
+	}, nil
+}
+
+func (srr subtreeRoleResolver) resolve(location string) models.Role {
+	for strings.HasPrefix(location, srr.location+"/") {
+		if roles, found := srr.assignments[location]; found && len(roles) != 0 {
+			return resolveRoles(roles)
+		}
+		location = location[:strings.LastIndex(location, "/")]
+	}
+	return srr.role
+}
+
+// allowedForSubtree checks the permission for every item of the subtree. The
+// workspace owner is always allowed.
+func (apifn ApiConfig) allowedForSubtree(claims models.JWTData, ownerId string, location string, items []any, permission func(models.Role) bool) (bool, error) {
+	if ownerId == claims.AccountId {
+		return true, nil
+	}
+	resolver, err := apifn.newSubtreeRoleResolver(claims.AccountId, location)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		if !permission(resolver.resolve(itemLocation(item))) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// allowedToUpload checks whether the account can upload a file to the
+// location. Uploading to the name of an existing file replaces its content,
+// which also takes the permission to delete that file. It reports whether the
+// file exists as well, as a new version does not add to the file count.
+func (apifn ApiConfig) allowedToUpload(accountId string, ownerId string, fileLocation string) (bool, bool, error) {
+	exists := true
+	_, err := apifn.graphService.GetFileDetails(fileLocation)
+	if errors.As(err, &apierrors.FileNotFound{}) {
+		exists = false
+	} else if err != nil {
+		return false, false, err
+	}
+	if ownerId == accountId {
+		return true, exists, nil
+	}
+	parentRole, err := apifn.getResolvedRole(accountId, fileLocation[:strings.LastIndex(fileLocation, "/")])
+	if err != nil {
+		return false, false, err
+	}
+	if !parentRole.CanCreate {
+		return false, exists, nil
+	}
+	if !exists {
+		return true, false, nil
+	}
+	fileRole, err := apifn.getResolvedRole(accountId, fileLocation)
+	if err != nil {
+		return false, false, err
+	}
+	return fileRole.CanDelete, true, nil
+}
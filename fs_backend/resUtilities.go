@@ -19,13 +19,13 @@ func ErrorResponseWriter(res http.ResponseWriter, errCode string, statusCode int
 		return
 	}
 	res.Header().Set("Content-Type", "application/json")
-	res.Header().Set("Access-Control-Allow-Origin", "*")
+	allowAnyOrigin(res)
 	res.WriteHeader(statusCode)
 	res.Write(data)
 }
 
 func JsonResponseWriter(res http.ResponseWriter, dataMap map[string]any, statusCode int) {
-	res.Header().Set("Access-Control-Allow-Origin", "*")
+	allowAnyOrigin(res)
 	res.WriteHeader(statusCode)
 	if len(dataMap) != 0 {
 		data, err := json.Marshal(dataMap)
@@ -37,3 +37,11 @@ func JsonResponseWriter(res http.ResponseWriter, dataMap map[string]any, statusC
 		res.Write(data)
 	}
 }
+
+// allowAnyOrigin opens the response to every origin, unless the handler
+// already allowed a configured one
+func allowAnyOrigin(res http.ResponseWriter) {
+	if res.Header().Get("Access-Control-Allow-Origin") == "" {
+		res.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+const (
+	// Most results a page of a search can hold
+	maxSearchLimit = 1000
+	// Batches of unreadable items looked through before a page is returned
+	// short, so a search cannot scan a whole workspace in one request
+	maxSearchBatches = 10
+)
+
+// indexFileText indexes the content of the file for search, unless content
+// shared with another file already is. Meant to run in the background.
+func (apifn ApiConfig) indexFileText(file models.File) {
+	if file.Sha256 == "" {
+		return
+	}
+	workspaceName := strings.Split(file.Location, "/")[0]
+	indexed, err := apifn.graphService.IsBlobTextIndexed(workspaceName, file.Sha256)
+	if err != nil || indexed {
+		return
+	}
+	text, err := apifn.fileService.ExtractText(file)
+	if err != nil {
+		log.Default().Println("Indexing", file.Location, "failed :", err.Error())
+		return
+	}
+	apifn.graphService.SetBlobText(workspaceName, file.Sha256, text)
+}
+
+// HandleSearch finds the items below a location by name, size, date, type,
+// uploader, tag, metadata and content. Only items the account can read are
+// returned, a page at a time.
+func (apifn ApiConfig) HandleSearch(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	options := models.SearchOptions{
+		Location:   query.Get("location"),
+		Name:       query.Get("name"),
+		Match:      query.Get("match"),
+		Type:       query.Get("type"),
+		Extension:  strings.TrimPrefix(query.Get("extension"), "."),
+		UploadedBy: query.Get("uploadedBy"),
+		Content:    strings.TrimSpace(query.Get("content")),
+		Tag:        query.Get("tag"),
+		Limit:      50,
+	}
+	// Metadata is matched as key:value
+	if metadata := query.Get("metadata"); metadata != "" {
+		key, value, found := strings.Cut(metadata, ":")
+		if !found || !validMetadataKey(key) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		options.MetadataKey, options.MetadataValue = key, value
+	}
+
+	// Checking whether the data is valid
+	if options.Location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	if options.Match == "" {
+		options.Match = "prefix"
+	}
+	if options.Match != "prefix" && options.Match != "substring" && options.Match != "glob" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if options.Type != "" && options.Type != "file" && options.Type != "directory" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	var err error
+	if options.MinSize, err = parseOptionalInt(query.Get("minSize")); err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if options.MaxSize, err = parseOptionalInt(query.Get("maxSize")); err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if options.From, err = parseOptionalTime(query.Get("from")); err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if options.To, err = parseOptionalTime(query.Get("to")); err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 1 || options.Limit > maxSearchLimit {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	// The cursor is the location of the last item of the previous page
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		options.After = string(after)
+	}
+	workspaceName := strings.Split(options.Location, "/")[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	isOwner := workspaceOwner.Id == claims.AccountId
+
+	// Roles deeper in the tree can grant reading below an unreadable location,
+	// so every result is checked on its own
+	var resolver subtreeRoleResolver
+	if !isOwner {
+		resolver, err = apifn.newSubtreeRoleResolver(claims.AccountId, options.Location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	results := []any{}
+	nextCursor := ""
+	for batch := 0; batch < maxSearchBatches && len(results) < options.Limit; batch++ {
+		items, err := apifn.graphService.SearchItems(options)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		examined := 0
+		for _, item := range items {
+			examined++
+			options.After = itemLocation(item)
+			if isOwner || resolver.resolve(options.After).CanRead {
+				results = append(results, item)
+				if len(results) == options.Limit {
+					break
+				}
+			}
+		}
+		// Nothing is left once a short batch has been looked through
+		if len(items) < options.Limit && examined == len(items) {
+			nextCursor = ""
+			break
+		}
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(options.After))
+	}
+
+	resData := make(map[string]any)
+	resData["results"] = results
+	resData["nextCursor"] = nextCursor
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+func parseOptionalInt(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+const (
+	maxTagLength           = 64
+	maxMetadataValueLength = 1024
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func validMetadataKey(key string) bool {
+	return metadataKeyPattern.MatchString(key)
+}
+
+// HandleItemTags returns the tags and metadata of an item on GET. POST adds
+// tags and sets metadata keys, DELETE removes tags and metadata keys. Changing
+// them needs the permission to rename the item.
+func (apifn ApiConfig) HandleItemTags(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var params struct {
+		Location string            `json:"location"`
+		Tags     []string          `json:"tags"`
+		Metadata map[string]string `json:"metadata"`
+		Keys     []string          `json:"keys"`
+	}
+	if req.Method == http.MethodGet {
+		params.Location = req.URL.Query().Get("location")
+	} else {
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Checking whether the data is valid
+	if params.Location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	for index, tag := range params.Tags {
+		params.Tags[index] = strings.TrimSpace(tag)
+		if params.Tags[index] == "" || len(params.Tags[index]) > maxTagLength {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	for key, value := range params.Metadata {
+		if !validMetadataKey(key) || len(value) > maxMetadataValueLength {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, key := range params.Keys {
+		if !validMetadataKey(key) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	workspaceName := strings.Split(params.Location, "/")[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Checking permissions
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, params.Location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanRead || (req.Method != http.MethodGet && !nearestRole.CanRename) {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Left out tags change nothing
+	if params.Tags == nil {
+		params.Tags = []string{}
+	}
+	var item any
+	switch req.Method {
+	case http.MethodGet:
+		item, err = apifn.graphService.GetItem(params.Location)
+	case http.MethodPost:
+		item, err = apifn.graphService.AddItemLabels(params.Location, params.Tags, params.Metadata)
+	case http.MethodDelete:
+		item, err = apifn.graphService.RemoveItemLabels(params.Location, params.Tags, params.Keys)
+	}
+	if err != nil {
+		if errors.Is(err, apierrors.FileNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	switch i := item.(type) {
+	case models.Directory:
+		resData["tags"], resData["metadata"] = i.Tags, i.Metadata
+	case models.File:
+		resData["tags"], resData["metadata"] = i.Tags, i.Metadata
+	}
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
@@ -1,6 +1,10 @@
 package transferpropertiesservice
 
 import (
+	"errors"
+	"log"
+	"os"
+	"sync"
 	"time"
 
 	"fs_backend/models"
@@ -8,21 +12,46 @@ import (
 	"github.com/jellydator/ttlcache/v3"
 )
 
+// TransferPropertiesService keeps the transfer sessions on disk so in-flight
+// transfers survive a restart. The cache only holds recently used sessions.
 type TransferPropertiesService struct {
-	isRunning bool
-	cache     *ttlcache.Cache[string, models.FileTransferProperties]
+	isRunning     bool
+	storeLocation string
+	sessionTTL    time.Duration
+	lock          *sync.Mutex
+	claimed       map[string]bool
+	cache         *ttlcache.Cache[string, models.FileTransferProperties]
 }
 
 func (ups *TransferPropertiesService) Start() {
+	location := os.Getenv("TRANSFER_STORE_LOCATION")
+	if location == "" {
+		location = "_transfers/"
+	}
+	ups.storeLocation = location
+	ups.sessionTTL = 24 * time.Hour
+	ups.lock = &sync.Mutex{}
+	ups.claimed = map[string]bool{}
+
+	// Creating the store directory
+	if _, err := os.Stat(ups.storeLocation); errors.Is(err, os.ErrNotExist) {
+		err := os.MkdirAll(ups.storeLocation, os.ModePerm)
+		if err != nil {
+			log.Default().Println(err.Error())
+		}
+	}
+
 	ups.cache = ttlcache.New[string, models.FileTransferProperties](
-		ttlcache.WithTTL[string, models.FileTransferProperties](24 * time.Hour),
+		ttlcache.WithTTL[string, models.FileTransferProperties](time.Hour),
 	)
 	go ups.cache.Start()
 	ups.isRunning = true
+
+	// Dropping sessions that expired while the server was down
+	ups.RemoveExpired()
 }
 
 func (ups *TransferPropertiesService) Stop() {
 	ups.cache.Stop()
-	ups.cache.DeleteAll()
 	ups.isRunning = false
 }
@@ -1,30 +1,198 @@
 package transferpropertiesservice
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"fs_backend/apierrors"
 	"fs_backend/models"
+
+	"github.com/google/uuid"
 )
 
+func (ups TransferPropertiesService) sessionLocation(uploadId string) string {
+	return filepath.Join(ups.storeLocation, uploadId+".json")
+}
+
+func (ups TransferPropertiesService) isExpired(properties models.FileTransferProperties) bool {
+	return time.Since(properties.LinkGenerated) > ups.sessionTTL
+}
+
 func (ups *TransferPropertiesService) Get(uploadId string) (models.FileTransferProperties, error) {
-	if ups.cache.Has(uploadId) {
-		res := ups.cache.Get(uploadId)
-		if res.IsExpired() {
-			ups.cache.Delete(uploadId)
+	// Ids are used as file names, so anything other than a UUID is rejected
+	if _, err := uuid.Parse(uploadId); err != nil {
+		return models.FileTransferProperties{}, apierrors.UploadIdNotFound{UploadId: uploadId}
+	}
+
+	if res := ups.cache.Get(uploadId); res != nil && !ups.isExpired(res.Value()) {
+		return res.Value(), nil
+	}
+
+	data, err := os.ReadFile(ups.sessionLocation(uploadId))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
 			return models.FileTransferProperties{}, apierrors.UploadIdNotFound{UploadId: uploadId}
-		} else {
-			return res.Value(), nil
 		}
-	} else {
+		log.Default().Println(err.Error())
+		return models.FileTransferProperties{}, err
+	}
+	var properties models.FileTransferProperties
+	err = json.Unmarshal(data, &properties)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return models.FileTransferProperties{}, err
+	}
+	if ups.isExpired(properties) {
+		ups.Delete(uploadId)
 		return models.FileTransferProperties{}, apierrors.UploadIdNotFound{UploadId: uploadId}
 	}
+	ups.cache.Set(uploadId, properties, time.Hour)
+	return properties, nil
 }
 
-func (ups TransferPropertiesService) Set(properties models.FileTransferProperties) {
-	ups.cache.Set(properties.LinkId, properties, time.Hour*24)
+func (ups TransferPropertiesService) Set(properties models.FileTransferProperties) error {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+
+	// Writing to a temporary file first so a crash never leaves a half written session
+	tempLocation := ups.sessionLocation(properties.LinkId) + ".tmp"
+	err = os.WriteFile(tempLocation, data, 0600)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	err = os.Rename(tempLocation, ups.sessionLocation(properties.LinkId))
+	if err != nil {
+		log.Default().Println(err.Error())
+		return err
+	}
+	ups.cache.Set(properties.LinkId, properties, time.Hour)
+	return nil
 }
 
 func (ups TransferPropertiesService) Delete(uploadId string) {
 	ups.cache.Delete(uploadId)
+	err := os.Remove(ups.sessionLocation(uploadId))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Default().Println(err.Error())
+	}
+}
+
+// AddReceivedChunk marks the chunk as received. The read-modify-write is
+// serialized so concurrent chunks of a session do not lose each other.
+func (ups *TransferPropertiesService) AddReceivedChunk(uploadId string, chunk int) (models.FileTransferProperties, error) {
+	ups.lock.Lock()
+	defer ups.lock.Unlock()
+
+	properties, err := ups.Get(uploadId)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	properties.ReceivedChunks = properties.ReceivedChunks.Set(chunk)
+	err = ups.Set(properties)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	return properties, nil
+}
+
+// SetContentHash saves the checksum progress of the session. It is serialized
+// with AddReceivedChunk so neither loses the other's update.
+func (ups *TransferPropertiesService) SetContentHash(uploadId string, hashState []byte, hashedBytes int64) (models.FileTransferProperties, error) {
+	ups.lock.Lock()
+	defer ups.lock.Unlock()
+
+	properties, err := ups.Get(uploadId)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	properties.HashState = hashState
+	properties.HashedBytes = hashedBytes
+	err = ups.Set(properties)
+	if err != nil {
+		return models.FileTransferProperties{}, err
+	}
+	return properties, nil
+}
+
+// RemoveExpired deletes every expired session from the store and returns them
+func (ups *TransferPropertiesService) RemoveExpired() []models.FileTransferProperties {
+	expired := []models.FileTransferProperties{}
+	entries, err := os.ReadDir(ups.storeLocation)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return expired
+	}
+	for _, entry := range entries {
+		uploadId, isSession := strings.CutSuffix(entry.Name(), ".json")
+		if !isSession {
+			continue
+		}
+		data, err := os.ReadFile(ups.sessionLocation(uploadId))
+		if err != nil {
+			log.Default().Println(err.Error())
+			continue
+		}
+		var properties models.FileTransferProperties
+		err = json.Unmarshal(data, &properties)
+		if err != nil || ups.isExpired(properties) {
+			ups.Delete(uploadId)
+			expired = append(expired, properties)
+		}
+	}
+	return expired
+}
+
+// Claim gives a request exclusive processing of a session, such as committing
+// a completed upload. Claims are kept in memory so a crash while processing
+// never blocks the session.
+func (ups *TransferPropertiesService) Claim(uploadId string) bool {
+	ups.lock.Lock()
+	defer ups.lock.Unlock()
+
+	if ups.claimed[uploadId] {
+		return false
+	}
+	ups.claimed[uploadId] = true
+	return true
+}
+
+func (ups *TransferPropertiesService) Release(uploadId string) {
+	ups.lock.Lock()
+	defer ups.lock.Unlock()
+
+	delete(ups.claimed, uploadId)
+}
+
+// GetStagedFileIds returns the ids of the files of every live session, after
+// removing the expired ones
+func (ups *TransferPropertiesService) GetStagedFileIds() (map[string]bool, error) {
+	ups.RemoveExpired()
+	entries, err := os.ReadDir(ups.storeLocation)
+	if err != nil {
+		log.Default().Println(err.Error())
+		return nil, err
+	}
+	fileIds := map[string]bool{}
+	for _, entry := range entries {
+		uploadId, isSession := strings.CutSuffix(entry.Name(), ".json")
+		if !isSession {
+			continue
+		}
+		properties, err := ups.Get(uploadId)
+		if err != nil {
+			// Removed since listing
+			continue
+		}
+		fileIds[properties.FileProperties.Id] = true
+	}
+	return fileIds, nil
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/fileservice"
+	"fs_backend/models"
+
+	"github.com/google/uuid"
+)
+
+// trashItem moves the file or directory at the location to the trash of its
+// workspace
+func (apifn ApiConfig) trashItem(location string, accountId string) (models.TrashEntry, error) {
+	return apifn.graphService.TrashItem(models.TrashEntry{
+		Id:            uuid.New().String(),
+		Location:      location,
+		WorkspaceName: strings.Split(location, "/")[0],
+		DeletedOn:     time.Now().UTC(),
+		DeletedBy:     accountId,
+	})
+}
+
+// purgeTrashEntry deletes the entry for good, and the content no other file
+// shares in the background
+func (apifn ApiConfig) purgeTrashEntry(entry models.TrashEntry) error {
+	unreferencedFiles, err := apifn.graphService.PurgeTrashEntry(entry)
+	if err != nil {
+		return err
+	}
+	apifn.fileService.DeleteUnreferencedContent(unreferencedFiles)
+	return nil
+}
+
+// HandleTrash lists the trash of a workspace on GET, restores an entry on POST
+// and purges an entry, or the whole trash without an id, on DELETE
+func (apifn ApiConfig) HandleTrash(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var params struct {
+		WorkspaceName string `json:"workspaceName"`
+		Id            string `json:"id"`
+	}
+	if req.Method == http.MethodPost {
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	} else {
+		params.WorkspaceName = req.URL.Query().Get("workspace")
+		params.Id = req.URL.Query().Get("id")
+	}
+
+	// Checking whether the data is valid
+	if params.WorkspaceName == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodPost && params.Id == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(params.WorkspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		if errors.As(err, &apierrors.WorkspaceNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	isOwner := workspaceOwner.Id == claims.AccountId
+
+	if req.Method == http.MethodGet {
+		// Service accounts only see what they deleted
+		deletedBy := ""
+		if !isOwner {
+			deletedBy = claims.AccountId
+		}
+		entries, err := apifn.graphService.GetTrashEntries(params.WorkspaceName, deletedBy)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		resData := make(map[string]any)
+		resData["entries"] = entries
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		// Purging cannot be undone, so only the owner can do it
+		if !isOwner {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+		entries := []models.TrashEntry{}
+		if params.Id == "" {
+			entries, err = apifn.graphService.GetTrashEntries(params.WorkspaceName, "")
+		} else {
+			var entry models.TrashEntry
+			entry, err = apifn.graphService.GetTrashEntry(params.WorkspaceName, params.Id)
+			entries = append(entries, entry)
+		}
+		if err != nil {
+			if errors.As(err, &apierrors.TrashEntryNotFound{}) {
+				ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+				return
+			}
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		for _, entry := range entries {
+			err = apifn.purgeTrashEntry(entry)
+			if err != nil {
+				log.Default().Println(err.Error())
+				ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+				return
+			}
+		}
+		resData := make(map[string]any)
+		resData["purged"] = len(entries)
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+
+	entry, err := apifn.graphService.GetTrashEntry(params.WorkspaceName, params.Id)
+	if err != nil {
+		if errors.As(err, &apierrors.TrashEntryNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Restoring puts the item back in its parent directory, like creating it.
+	// Service accounts can only restore what they deleted, as it is all they see.
+	if !isOwner {
+		if entry.DeletedBy != claims.AccountId {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, entry.Location[:strings.LastIndex(entry.Location, "/")])
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanCreate {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	err = apifn.graphService.RestoreTrashEntry(entry)
+	if err != nil {
+		if errors.As(err, &apierrors.DirectoryNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusConflict)
+			return
+		}
+		if errors.As(err, &apierrors.FileWithSameNameAlreadyExists{}) {
+			ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusConflict)
+			return
+		}
+		if errors.As(err, &apierrors.DirectoryWithSameNameAlreadyExists{}) {
+			ErrorResponseWriter(res, apierrors.ResErrDirAlreadyExists, http.StatusConflict)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	resData["location"] = entry.Location
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+// startTrashPurge purges entries older than the retention of their workspace
+// every TRASH_PURGE_INTERVAL, an hour by default. An interval of 0 disables it.
+func (apifn *ApiConfig) startTrashPurge() {
+	interval := fileservice.ParseDurationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	apifn.trashPurgeStop = make(chan struct{})
+	if interval == 0 {
+		return
+	}
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				entries, err := apifn.graphService.GetExpiredTrashEntries()
+				if err != nil {
+					log.Default().Println("Trash purge failed :", err.Error())
+					continue
+				}
+				for _, entry := range entries {
+					err = apifn.purgeTrashEntry(entry)
+					if err != nil {
+						log.Default().Println("Trash purge failed for", entry.Location, ":", err.Error())
+					}
+				}
+				if len(entries) != 0 {
+					log.Default().Printf("Trash purge removed %d entries\n", len(entries))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(apifn.trashPurgeStop)
+}
+
+func (apifn ApiConfig) stopTrashPurge() {
+	if apifn.trashPurgeStop != nil {
+		close(apifn.trashPurgeStop)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+// Deepest tree a single request can ask for
+const maxTreeDepth = 16
+
+// HandleTree returns the directory at the location with everything below it
+// down to the depth, one level by default. Items the account cannot read are
+// left out along with everything below them, and from the counts of the
+// directories holding them.
+func (apifn ApiConfig) HandleTree(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	location := query.Get("location")
+
+	// Checking whether the data is valid
+	if location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	depth := 1
+	if depthParam := query.Get("depth"); depthParam != "" {
+		var err error
+		depth, err = strconv.Atoi(depthParam)
+		if err != nil || depth < 0 || depth > maxTreeDepth {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	workspaceName := strings.Split(location, "/")[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Roles of the whole subtree are read at once, instead of a query per item
+	var resolver subtreeRoleResolver
+	if workspaceOwner.Id != claims.AccountId {
+		resolver, err = apifn.newSubtreeRoleResolver(claims.AccountId, location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !resolver.role.CanRead {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Other accounts get counts of what they can read, so the items of the
+	// directories at the depth are read too, to be counted and left out
+	treeDepth := depth
+	if workspaceOwner.Id != claims.AccountId {
+		treeDepth = depth + 1
+	}
+	nodes, err := apifn.graphService.GetTree(location, treeDepth)
+	if err != nil {
+		if errors.Is(err, apierrors.DirectoryNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Nodes come parents first, so an item is placed once its parent is. Items
+	// under a left out directory find no parent and are left out too.
+	placed := map[string]*models.TreeNode{}
+	root := &nodes[0]
+	placed[location] = root
+	for i := 1; i < len(nodes); i++ {
+		node := &nodes[i]
+		nodeLocation := itemLocation(node.Item)
+		parent, found := placed[nodeLocation[:strings.LastIndex(nodeLocation, "/")]]
+		if !found {
+			continue
+		}
+		if workspaceOwner.Id != claims.AccountId && !resolver.resolve(nodeLocation).CanRead {
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+		if _, isDirectory := node.Item.(models.Directory); isDirectory {
+			placed[nodeLocation] = node
+		}
+	}
+
+	// Counts of directories are of what the account can see
+	if workspaceOwner.Id != claims.AccountId {
+		for _, node := range placed {
+			if node.Depth > depth {
+				continue
+			}
+			node.Directories, node.Files, node.Bytes = 0, 0, 0
+			for _, child := range node.Children {
+				if file, isFile := child.Item.(models.File); isFile {
+					node.Files++
+					node.Bytes += int64(file.Size)
+				} else {
+					node.Directories++
+				}
+			}
+			if node.Depth == depth {
+				node.Children = []*models.TreeNode{}
+			}
+		}
+	}
+
+	resData := make(map[string]any)
+	resData["tree"] = root
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+
+	"github.com/google/uuid"
+)
+
+const tusVersion = "1.0.0"
+
+// Status code of the tus checksum extension for a mismatching checksum
+const tusStatusChecksumMismatch = 460
+
+// HandleTus serves the tus 1.0 resumable upload protocol with the creation,
+// termination and checksum extensions. Uploads are kept as transfer sessions
+// like the chunked uploads and committed the same way. OPTIONS requests carry
+// no token, whether asking for the capabilities or a browser preflight, so
+// they are answered before authentication.
+func (apifn ApiConfig) HandleTus(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Tus-Resumable", tusVersion)
+	if apifn.corsOrigin != "" {
+		res.Header().Set("Access-Control-Allow-Origin", apifn.corsOrigin)
+		res.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Checksum-Algorithm")
+	}
+
+	if req.Method == http.MethodOptions {
+		res.Header().Set("Tus-Version", tusVersion)
+		res.Header().Set("Tus-Extension", "creation,termination,checksum")
+		res.Header().Set("Tus-Checksum-Algorithm", "sha1,md5,sha256")
+		if apifn.corsOrigin != "" {
+			res.Header().Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, DELETE, OPTIONS")
+			res.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum")
+			res.Header().Set("Access-Control-Max-Age", "86400")
+		}
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	apifn.authMiddleware(apifn.handleTusRequest)(res, req)
+}
+
+func (apifn ApiConfig) handleTusRequest(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		res.Header().Set("Tus-Version", tusVersion)
+		ErrorResponseWriter(res, apierrors.ResErrUnsupportedVersion, http.StatusPreconditionFailed)
+		return
+	}
+
+	uploadId := strings.Trim(strings.TrimPrefix(req.URL.Path, "/fs/tus"), "/")
+	if uploadId == "" {
+		if req.Method != http.MethodPost {
+			ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+			return
+		}
+		apifn.handleTusCreation(res, req, claims)
+		return
+	}
+
+	if req.Method != http.MethodHead && req.Method != http.MethodPatch && req.Method != http.MethodDelete {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Getting upload properties
+	properties, err := apifn.transferPropsService.Get(uploadId)
+	if err != nil {
+		if errors.As(err, &apierrors.UploadIdNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if properties.Protocol != "tus" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusNotFound)
+		return
+	}
+
+	// Only the account that created the upload can use it
+	if properties.AccountId != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		res.Header().Set("Cache-Control", "no-store")
+		res.Header().Set("Upload-Length", strconv.Itoa(properties.FileProperties.Size))
+		res.Header().Set("Upload-Offset", strconv.FormatInt(properties.UploadOffset, 10))
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only one request may work on an upload at a time
+	if !apifn.transferPropsService.Claim(uploadId) {
+		ErrorResponseWriter(res, apierrors.ResErrUploadLocked, http.StatusLocked)
+		return
+	}
+	defer apifn.transferPropsService.Release(uploadId)
+
+	// Reading the session again now that it is claimed
+	properties, err = apifn.transferPropsService.Get(uploadId)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrInvalidUploadId, http.StatusNotFound)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		// A committed upload keeps its data, only the session is dropped
+		if !properties.Completed {
+			apifn.fileService.DeleteStagedFile(properties.FileProperties)
+		}
+		apifn.transferPropsService.Delete(uploadId)
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	apifn.handleTusPatch(res, req, properties)
+}
+
+func (apifn ApiConfig) handleTusCreation(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	location := req.URL.Query().Get("location")
+
+	// Checking whether the data is valid
+	if location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	uploadLength, err := strconv.Atoi(req.Header.Get("Upload-Length"))
+	if err != nil || uploadLength <= 0 {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	metadata := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	if name == "" || strings.Contains(name, "/") {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	// The checksum of the whole file is optional, but has to be a SHA-256 hex digest
+	expectedSha256 := strings.ToLower(metadata["sha256"])
+	if digest, err := hex.DecodeString(expectedSha256); err != nil || (expectedSha256 != "" && len(digest) != 32) {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+
+	locationSplit := strings.Split(location, "/")
+	workspaceName := locationSplit[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Checking permissions
+	allowed, exists, err := apifn.allowedToUpload(claims.AccountId, workspaceOwner.Id, location+"/"+name)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	// Refusing the upload up front when it would not fit in the quota
+	addedFiles := int64(1)
+	if exists {
+		addedFiles = 0
+	}
+	err = apifn.checkQuota(workspaceName, claims.AccountId, int64(uploadLength), addedFiles)
+	if err != nil {
+		if errors.As(err, &apierrors.QuotaExceeded{}) {
+			ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Creating upload properties for internal use
+	uploadProperties := models.FileTransferProperties{
+		LinkId: uuid.New().String(),
+		FileProperties: models.File{
+			Id:        uuid.New().String(),
+			Type:      "file",
+			Name:      name,
+			CreatedOn: time.Now().UTC(),
+			Size:      uploadLength,
+			Location:  location + "/" + name,
+		},
+		LinkGenerated:  time.Now(),
+		AccountId:      claims.AccountId,
+		WorkspaceName:  workspaceName,
+		Protocol:       "tus",
+		ExpectedSha256: expectedSha256,
+	}
+
+	// Storing upload details
+	err = apifn.transferPropsService.Set(uploadProperties)
+	if err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Location", "/fs/tus/"+uploadProperties.LinkId)
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (apifn ApiConfig) handleTusPatch(res http.ResponseWriter, req *http.Request, properties models.FileTransferProperties) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if offset != properties.UploadOffset || properties.Completed {
+		ErrorResponseWriter(res, apierrors.ResErrOffsetMismatch, http.StatusConflict)
+		return
+	}
+
+	remaining := int64(properties.FileProperties.Size) - offset
+	if req.ContentLength > remaining {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Verifying the checksum of the request body when the client sends one
+	var checksumHash hash.Hash
+	var expectedChecksum []byte
+	if checksumHeader := req.Header.Get("Upload-Checksum"); checksumHeader != "" {
+		algorithm, encodedChecksum, found := strings.Cut(checksumHeader, " ")
+		if !found {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		switch algorithm {
+		case "sha1":
+			checksumHash = sha1.New()
+		case "md5":
+			checksumHash = md5.New()
+		case "sha256":
+			checksumHash = sha256.New()
+		default:
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		expectedChecksum, err = base64.StdEncoding.DecodeString(encodedChecksum)
+		if err != nil {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var body io.Reader = io.LimitReader(req.Body, remaining)
+	if checksumHash != nil {
+		body = io.TeeReader(body, checksumHash)
+	}
+
+	// Computing the file checksum while the data is written
+	var contentHash hash.Hash
+	if properties.HashedBytes == offset {
+		contentHash, err = apifn.fileService.ResumeContentHash(properties.HashState)
+		if err == nil {
+			body = io.TeeReader(body, contentHash)
+		}
+	}
+	written, err := apifn.fileService.WriteStreamAt(properties.FileProperties, offset, body)
+	if err != nil && checksumHash != nil {
+		// Data that cannot be verified is discarded
+		apifn.fileService.TruncateFile(properties.FileProperties, offset)
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if checksumHash != nil && string(checksumHash.Sum(nil)) != string(expectedChecksum) {
+		apifn.fileService.TruncateFile(properties.FileProperties, offset)
+		ErrorResponseWriter(res, apierrors.ResErrChecksumMismatch, tusStatusChecksumMismatch)
+		return
+	}
+
+	// Keeping whatever was received, even from an interrupted request
+	properties.UploadOffset = offset + written
+	if contentHash != nil && err == nil {
+		if hashState, hashErr := apifn.fileService.SaveContentHash(contentHash); hashErr == nil {
+			properties.HashState = hashState
+			properties.HashedBytes = properties.UploadOffset
+		}
+	}
+	setErr := apifn.transferPropsService.Set(properties)
+	if err != nil || setErr != nil {
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// The start of the file tells the type, so a refused file is not sent in full
+	if offset == 0 && written > 0 {
+		_, err = apifn.checkUploadMimeType(properties)
+		if errors.As(err, &apierrors.MimeTypeNotAllowed{}) {
+			apifn.rejectUpload(properties)
+			ErrorResponseWriter(res, apierrors.ResErrMimeTypeNotAllowed, http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			log.Default().Println(err.Error())
+		}
+	}
+
+	if properties.UploadOffset == int64(properties.FileProperties.Size) {
+		_, err = apifn.commitUpload(properties.LinkId)
+		if err != nil {
+			log.Default().Println(err.Error())
+			if errors.As(err, &apierrors.ChecksumMismatch{}) {
+				ErrorResponseWriter(res, apierrors.ResErrChecksumMismatch, tusStatusChecksumMismatch)
+				return
+			}
+			if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+				ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+				return
+			}
+			if errors.As(err, &apierrors.QuotaExceeded{}) {
+				ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+				return
+			}
+			if errors.As(err, &apierrors.MimeTypeNotAllowed{}) {
+				ErrorResponseWriter(res, apierrors.ResErrMimeTypeNotAllowed, http.StatusUnsupportedMediaType)
+				return
+			}
+			if errors.As(err, &apierrors.FileWithSameNameAlreadyExists{}) {
+				ErrorResponseWriter(res, apierrors.ResErrFileAlreadyExists, http.StatusBadRequest)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	res.Header().Set("Upload-Offset", strconv.FormatInt(properties.UploadOffset, 10))
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata decodes the Upload-Metadata header, a comma separated list
+// of keys with optional base64 encoded values.
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, encodedValue, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   map[string]string
+	}{
+		{name: "empty", header: "", want: map[string]string{}},
+		{
+			name:   "pairs",
+			header: "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,location d3MvZG9jcw==",
+			want:   map[string]string{"filename": "world_domination_plan.pdf", "location": "ws/docs"},
+		},
+		{
+			name:   "spaces around pairs",
+			header: " filename YS50eHQ= , location d3M= ",
+			want:   map[string]string{"filename": "a.txt", "location": "ws"},
+		},
+		{name: "key without value", header: "is_confidential", want: map[string]string{"is_confidential": ""}},
+		{name: "invalid value is left out", header: "filename %%%,location d3M=", want: map[string]string{"location": "ws"}},
+		{name: "empty pairs are left out", header: ",,filename YS50eHQ=,", want: map[string]string{"filename": "a.txt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseTusMetadata(test.header); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
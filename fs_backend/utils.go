@@ -1,6 +1,15 @@
 package main
 
-import "unicode"
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"fs_backend/models"
+)
 
 func verifyPasswordStrength(password string) bool {
 	/*
@@ -33,3 +42,56 @@ func verifyPasswordStrength(password string) bool {
 
 	return hasMin8Chars && hasNumber && hasSymbol && hasUppercase && hasLowercase
 }
+
+// itemLocation returns the location of a directory or file
+func itemLocation(item any) string {
+	switch i := item.(type) {
+	case models.Directory:
+		return i.Location
+	case models.File:
+		return i.Location
+	}
+	return ""
+}
+
+// parseSha256Digest decodes a digest header of the form "sha-256=<base64>"
+func parseSha256Digest(header string) ([]byte, bool) {
+	algorithm, encodedDigest, found := strings.Cut(header, "=")
+	if !found || !strings.EqualFold(algorithm, "sha-256") {
+		return nil, false
+	}
+	digest, err := base64.StdEncoding.DecodeString(encodedDigest)
+	if err != nil || len(digest) != 32 {
+		return nil, false
+	}
+	return digest, true
+}
+
+// sha256DigestHeader formats the hex checksum of a file as a digest header
+func sha256DigestHeader(sha256 string) string {
+	digest, err := hex.DecodeString(sha256)
+	if err != nil {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(digest)
+}
+
+// setChecksumHeaders lets clients verify the content of a downloaded file
+func setChecksumHeaders(res http.ResponseWriter, file models.File) {
+	if file.Sha256 == "" {
+		res.Header().Set("ETag", `"`+file.Id+`"`)
+		return
+	}
+	res.Header().Set("ETag", `"`+file.Sha256+`"`)
+	res.Header().Set("Digest", sha256DigestHeader(file.Sha256))
+}
+
+// setChunkChecksumHeaders lets clients verify a downloaded chunk by its own
+// digest, and the whole file once put together by its checksum
+func setChunkChecksumHeaders(res http.ResponseWriter, file models.File, chunk []byte) {
+	chunkSum := sha256.Sum256(chunk)
+	res.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(chunkSum[:]))
+	if file.Sha256 != "" {
+		res.Header().Set("File-Sha256", file.Sha256)
+	}
+}
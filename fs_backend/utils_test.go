@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"fs_backend/models"
+)
+
+func TestParseSha256Digest(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		header string
+		want   []byte
+		ok     bool
+	}{
+		{name: "valid", header: "sha-256=" + encoded, want: sum[:], ok: true},
+		{name: "algorithm in any case", header: "SHA-256=" + encoded, want: sum[:], ok: true},
+		{name: "empty", header: "", ok: false},
+		{name: "no algorithm", header: encoded, ok: false},
+		{name: "other algorithm", header: "md5=" + encoded, ok: false},
+		{name: "invalid base64", header: "sha-256=not base64!", ok: false},
+		{name: "short digest", header: "sha-256=" + base64.StdEncoding.EncodeToString(sum[:16]), ok: false},
+		{name: "unpadded", header: "sha-256=" + base64.RawStdEncoding.EncodeToString(sum[:]), ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseSha256Digest(test.header)
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("got %x, want %x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSha256DigestHeader(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	header := sha256DigestHeader("ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73")
+	got, ok := parseSha256Digest(header)
+	if !ok || !bytes.Equal(got, sum[:]) {
+		t.Errorf("header %q does not parse back to the checksum", header)
+	}
+	if header := sha256DigestHeader("not hex"); header != "" {
+		t.Errorf("got %q for an invalid checksum, want nothing", header)
+	}
+}
+
+func TestSetChunkChecksumHeaders(t *testing.T) {
+	chunk := []byte("content")
+	sum := sha256.Sum256(chunk)
+	file := models.File{Sha256: "0123"}
+
+	res := httptest.NewRecorder()
+	setChunkChecksumHeaders(res, file, chunk)
+	digest, ok := parseSha256Digest(res.Header().Get("Digest"))
+	if !ok || !bytes.Equal(digest, sum[:]) {
+		t.Errorf("digest %q is not of the chunk", res.Header().Get("Digest"))
+	}
+	if got := res.Header().Get("File-Sha256"); got != file.Sha256 {
+		t.Errorf("got file checksum %q, want %q", got, file.Sha256)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fs_backend/apierrors"
+	"fs_backend/models"
+)
+
+// getFileAtVersion returns the file as it was at the version, or as it is now
+// when no version is given
+func (apifn ApiConfig) getFileAtVersion(location string, versionParam string) (models.File, error) {
+	file, err := apifn.graphService.GetFileDetails(location)
+	if err != nil || versionParam == "" {
+		return file, err
+	}
+	versionNumber, err := strconv.Atoi(versionParam)
+	if err != nil {
+		return models.File{}, apierrors.FileNotFound{}
+	}
+	if versionNumber == file.Version {
+		return file, nil
+	}
+	version, err := apifn.graphService.GetFileVersion(file.Id, versionNumber)
+	if err != nil {
+		return models.File{}, err
+	}
+	return file.WithVersion(version), nil
+}
+
+// HandleFileVersions lists the versions of a file on GET and restores one of
+// them on POST. Restoring adds the old content as the newest version, so the
+// history is kept.
+func (apifn ApiConfig) HandleFileVersions(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var params struct {
+		Location string `json:"location"`
+		Version  int    `json:"version"`
+	}
+	if req.Method == http.MethodGet {
+		params.Location = req.URL.Query().Get("location")
+	} else {
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Checking whether the data is valid
+	if params.Location == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidLocation, http.StatusBadRequest)
+		return
+	}
+	workspaceName := strings.Split(params.Location, "/")[0]
+
+	// Checking whether it is the owner of the workspace
+	workspaceOwner, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Checking permissions, restoring changes the file like an upload does
+	if workspaceOwner.Id != claims.AccountId {
+		nearestRole, err := apifn.getResolvedRole(claims.AccountId, params.Location)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if !nearestRole.CanRead || (req.Method == http.MethodPost && !nearestRole.CanCreate) {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+			return
+		}
+	}
+
+	file, err := apifn.graphService.GetFileDetails(params.Location)
+	if err != nil {
+		if errors.Is(err, apierrors.FileNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		versions, err := apifn.graphService.GetFileVersions(file.Id)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		resData := make(map[string]any)
+		resData["versions"] = append([]models.FileVersion{file.CurrentVersion()}, versions...)
+		JsonResponseWriter(res, resData, http.StatusOK)
+		return
+	}
+
+	// The current version cannot be restored
+	if params.Version == file.Version {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	version, err := apifn.graphService.GetFileVersion(file.Id, params.Version)
+	if err != nil {
+		if errors.Is(err, apierrors.FileNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	releaseQuota, err := apifn.reserveQuota(workspaceName, claims.AccountId, int64(version.Size), 0)
+	if err != nil {
+		if errors.As(err, &apierrors.QuotaExceeded{}) {
+			ErrorResponseWriter(res, apierrors.ResErrQuotaExceeded, http.StatusInsufficientStorage)
+			return
+		}
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	defer releaseQuota()
+	settings, err := apifn.graphService.GetWorkspaceSettings(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	releaseBlobs := apifn.fileService.HoldBlobs()
+	defer releaseBlobs()
+
+	// Content stored before checksums is named by the file id, which the
+	// restored version must not share with the old one. It is copied to a
+	// blob named by its checksum instead.
+	if version.Sha256 == "" {
+		version, err = apifn.hashVersionContent(file, version)
+		if err != nil {
+			log.Default().Println(err.Error())
+			if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+				ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	restoredFile, unreferencedFiles, err := apifn.graphService.RestoreFileVersion(file, version, claims.AccountId, settings.VersionRetention)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	go apifn.indexFileText(restoredFile)
+
+	// Deleting the content of versions dropped by the retention
+	apifn.fileService.DeleteUnreferencedContent(unreferencedFiles)
+
+	resData := make(map[string]any)
+	resData["file"] = restoredFile
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+// hashVersionContent gives a version stored before checksums its checksum,
+// storing a copy of its content under it
+func (apifn ApiConfig) hashVersionContent(file models.File, version models.FileVersion) (models.FileVersion, error) {
+	legacyFile := file.WithVersion(version)
+	sha256, err := apifn.fileService.ContentSha256(legacyFile)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	hashedFile := legacyFile
+	hashedFile.Sha256 = sha256
+	if _, err := apifn.fileService.StoredSize(hashedFile); err != nil {
+		if !errors.As(err, &apierrors.BlobNotFound{}) {
+			return models.FileVersion{}, err
+		}
+		err = apifn.fileService.CopyFile(legacyFile, hashedFile)
+		if err != nil {
+			return models.FileVersion{}, err
+		}
+	}
+	storedSize, err := apifn.fileService.StoredSize(hashedFile)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	version.Sha256 = sha256
+	version.StoredSize = int(storedSize)
+	return version, nil
+}
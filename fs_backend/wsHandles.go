@@ -66,6 +66,20 @@ func (apifn ApiConfig) handleWorkspaceOperations(res http.ResponseWriter, req *h
 			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
 			return
 		}
+		workspaceNames := []string{}
+		for _, workspace := range workspaces {
+			workspaceNames = append(workspaceNames, workspace.Name)
+		}
+		usages, err := apifn.graphService.GetWorkspacesUsage(workspaceNames)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		for index := range workspaces {
+			usage := usages[workspaces[index].Name]
+			workspaces[index].Usage = &usage
+		}
 		resp := make(map[string]any)
 		resp["workspaces"] = workspaces
 		JsonResponseWriter(res, resp, http.StatusOK)
@@ -166,6 +180,235 @@ func (apifn ApiConfig) handleWorkspaceOperations(res http.ResponseWriter, req *h
 	}
 }
 
+func (apifn ApiConfig) handleWorkspaceSettings(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	// Enforcing only GET and PATCH methods
+	if req.Method != http.MethodGet && req.Method != http.MethodPatch {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only owner accounts are allowed
+	if !claims.IsOwner {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	// Settings left out of the body keep their value
+	var params struct {
+		WorkspaceName    string `json:"workspaceName"`
+		Compression      *bool  `json:"compression"`
+		QuotaBytes       *int64 `json:"quotaBytes"`
+		QuotaFiles       *int64 `json:"quotaFiles"`
+		VersionRetention *int64 `json:"versionRetention"`
+		// Days deleted items stay in the trash, 0 keeps them until emptied
+		TrashRetentionDays *int64   `json:"trashRetentionDays"`
+		AllowedMimeTypes   []string `json:"allowedMimeTypes"`
+		DeniedMimeTypes    []string `json:"deniedMimeTypes"`
+	}
+	if req.Method == http.MethodGet {
+		params.WorkspaceName = req.URL.Query().Get("workspace")
+	} else {
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		if (params.QuotaBytes != nil && *params.QuotaBytes < 0) || (params.QuotaFiles != nil && *params.QuotaFiles < 0) ||
+			(params.VersionRetention != nil && *params.VersionRetention < 0) ||
+			(params.TrashRetentionDays != nil && *params.TrashRetentionDays < 0) ||
+			!validMimeTypePatterns(params.AllowedMimeTypes) || !validMimeTypePatterns(params.DeniedMimeTypes) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+	}
+	if params.WorkspaceName == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether the account in the token is the owner of the workspace
+	ownerInDb, err := apifn.graphService.GetWorkspaceOwner(params.WorkspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if ownerInDb.Id != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	settings, err := apifn.graphService.GetWorkspaceSettings(params.WorkspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		if errors.Is(err, apierrors.WorkspaceNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if req.Method == http.MethodPatch {
+		if params.Compression != nil {
+			settings.Compression = *params.Compression
+		}
+		if params.QuotaBytes != nil {
+			settings.QuotaBytes = *params.QuotaBytes
+		}
+		if params.QuotaFiles != nil {
+			settings.QuotaFiles = *params.QuotaFiles
+		}
+		// Files are trimmed to the retention when they next get a version
+		if params.VersionRetention != nil {
+			settings.VersionRetention = *params.VersionRetention
+		}
+		if params.TrashRetentionDays != nil {
+			settings.TrashRetentionDays = *params.TrashRetentionDays
+		}
+		// An empty list clears the patterns, a left out one keeps them
+		if params.AllowedMimeTypes != nil {
+			settings.AllowedMimeTypes = params.AllowedMimeTypes
+		}
+		if params.DeniedMimeTypes != nil {
+			settings.DeniedMimeTypes = params.DeniedMimeTypes
+		}
+		err = apifn.graphService.UpdateWorkspaceSettings(params.WorkspaceName, settings)
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resData := make(map[string]any)
+	resData["settings"] = settings
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+// handleWorkspaceUsage reports where the space of the workspace goes, by top
+// level item, by file type and by uploading account
+func (apifn ApiConfig) handleWorkspaceUsage(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	// Enforcing only GET method
+	if req.Method != http.MethodGet {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only owner accounts are allowed
+	if !claims.IsOwner {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	workspaceName := req.URL.Query().Get("workspace")
+	if workspaceName == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether the account in the token is the owner of the workspace
+	ownerInDb, err := apifn.graphService.GetWorkspaceOwner(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		if errors.Is(err, apierrors.WorkspaceNotFound{}) {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if ownerInDb.Id != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	report, err := apifn.graphService.GetUsageShares(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	report.Usage, err = apifn.getWorkspaceUsage(workspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	resData := make(map[string]any)
+	resData["report"] = report
+	JsonResponseWriter(res, resData, http.StatusOK)
+}
+
+// handleWorkspaceKeyRotation switches the workspace to a new data key and
+// re-encrypts the existing blobs in the background
+func (apifn ApiConfig) handleWorkspaceKeyRotation(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
+	// Enforcing only POST method
+	if req.Method != http.MethodPost {
+		ErrorResponseWriter(res, apierrors.ResErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only owner accounts are allowed
+	if !claims.IsOwner {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	var params struct {
+		WorkspaceName string `json:"workspaceName"`
+	}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+		return
+	}
+	if params.WorkspaceName == "" {
+		ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+		return
+	}
+
+	// Checking whether the account in the token is the owner of the workspace
+	ownerInDb, err := apifn.graphService.GetWorkspaceOwner(params.WorkspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+	if ownerInDb.Id != claims.AccountId {
+		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusForbidden)
+		return
+	}
+
+	keyVersion, err := apifn.fileService.RotateWorkspaceKey(params.WorkspaceName)
+	if err != nil {
+		log.Default().Println(err.Error())
+		if errors.As(err, &apierrors.EncryptionKeyUnavailable{}) {
+			ErrorResponseWriter(res, apierrors.ResErrEncryptionUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Files stay readable with the older key until they are re-encrypted
+	go func() {
+		err := apifn.fileService.ReencryptWorkspace(params.WorkspaceName)
+		if err != nil {
+			log.Default().Println("Error re-encrypting workspace", params.WorkspaceName, ":", err.Error())
+		}
+	}()
+
+	resData := make(map[string]any)
+	resData["keyVersion"] = keyVersion
+	JsonResponseWriter(res, resData, http.StatusAccepted)
+}
+
 func (apifn ApiConfig) handleWorkspaceAccountOperations(res http.ResponseWriter, req *http.Request, claims models.JWTData) {
 	if !claims.IsOwner {
 		ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusUnauthorized)
@@ -293,6 +536,57 @@ func (apifn ApiConfig) handleWorkspaceAccountOperations(res http.ResponseWriter,
 		JsonResponseWriter(res, resData, http.StatusCreated)
 		return
 	}
+	if req.Method == http.MethodPatch {
+		// A quota of 0 removes the limit of the account
+		var params struct {
+			WorkspaceName string `json:"workspaceName"`
+			Username      string `json:"username"`
+			QuotaBytes    *int64 `json:"quotaBytes"`
+		}
+		decoder := json.NewDecoder(req.Body)
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			log.Default().Println(err.Error())
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		if params.WorkspaceName == "" {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+			return
+		}
+		if params.Username == "" || params.QuotaBytes == nil || *params.QuotaBytes < 0 {
+			ErrorResponseWriter(res, apierrors.ResErrInvalidData, http.StatusBadRequest)
+			return
+		}
+		// Fetching owner details from database
+		ownerInDb, err := apifn.graphService.GetWorkspaceOwner(params.WorkspaceName)
+		if err != nil {
+			log.Default().Println(err.Error())
+			if errors.Is(err, apierrors.WorkspaceNotFound{}) {
+				ErrorResponseWriter(res, apierrors.ResErrInvalidWorkspaceName, http.StatusBadRequest)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		if ownerInDb.Id != claims.AccountId {
+			ErrorResponseWriter(res, apierrors.ResErrPermissionDenied, http.StatusUnauthorized)
+			return
+		}
+		err = apifn.graphService.SetServiceAccountQuota(params.WorkspaceName, params.Username, *params.QuotaBytes)
+		if err != nil {
+			log.Default().Println(err.Error())
+			if errors.Is(err, apierrors.AccountNotFound{}) {
+				ErrorResponseWriter(res, apierrors.ResErrSANotFound, http.StatusBadRequest)
+				return
+			}
+			ErrorResponseWriter(res, apierrors.ResErrServerError, http.StatusInternalServerError)
+			return
+		}
+		JsonResponseWriter(res, map[string]any{}, http.StatusOK)
+		return
+	}
 	if req.Method == http.MethodDelete {
 		// Checking whether the user is the owner of the workspace
 		var params struct {
@@ -78,7 +78,7 @@ class TransferRepo extends ApiRepo {
         uploadRequest.headers['Chunk-Total'] = totalChunkNumber.toString();
         uploadRequest.headers['Chunk-Current'] = chunkNumber.toString();
         uploadRequest.headers['Authorization'] = 'bearer $token';
-        uploadRequest.body = jsonEncode({'data': base64Encode(chunkData)});
+        uploadRequest.bodyBytes = chunkData;
 
         final uploadResponse = await client.send(uploadRequest);
 